@@ -1,30 +1,20 @@
 package main
 
 import (
-    "log"
-    "bytes"
-    "net/http"
-    "github.com/divan/gorilla-xmlrpc/xml"
+	"context"
+	"log"
+
+	"github.com/divan/gorilla-xmlrpc/xml"
 )
 
-func XmlRpcCall(method string, args struct{Who string}) (reply struct{Message string}, err error) {
-    buf, _ := xml.EncodeClientRequest(method, &args)
+func main() {
+	client := xml.NewClient("http://localhost:1234/RPC2", nil)
 
-    resp, err := http.Post("http://localhost:1234/RPC2", "text/xml", bytes.NewBuffer(buf))
-    if err != nil {
-        return
-    }
-    defer resp.Body.Close()
+	args := struct{ Who string }{"User 1"}
+	var reply struct{ Message string }
+	if err := client.Call(context.Background(), "HelloService.Say", &args, &reply); err != nil {
+		log.Fatal(err)
+	}
 
-    err = xml.DecodeClientResponse(resp.Body, &reply)
-    return
+	log.Printf("Response: %s\n", reply.Message)
 }
-
-func main() {
-    reply, err := XmlRpcCall("HelloService.Say", struct{Who string}{"User 1"})
-    if err != nil {
-        log.Fatal(err)
-    }
-
-    log.Printf("Response: %s\n", reply.Message)
-}
\ No newline at end of file
@@ -5,22 +5,171 @@
 package xml
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
+	"mime"
+	"net/http"
 )
 
-// EncodeClientRequest encodes parameters for a XML-RPC client request.
-func EncodeClientRequest(method string, args interface{}) ([]byte, error) {
-	xml, err := rpcRequest2XML(method, args)
-	return []byte(xml), err
+// DefaultMaxResponseSize is the response body limit used by Client when
+// MaxResponseSize is not set.
+const DefaultMaxResponseSize = 10 << 20
+
+// ErrResponseTooLarge is returned by Client.Call when the response body
+// exceeds the client's MaxResponseSize.
+var ErrResponseTooLarge = errors.New("xml: response body too large")
+
+// StatusError is returned by Client.Call when the server replies with a
+// HTTP status other than 200 OK, without a fault in the body. Faults sent
+// with another status, see WithFaultStatus, are returned as by Call.
+type StatusError struct {
+	StatusCode int
+	Status     string
 }
 
-// DecodeClientResponse decodes the response body of a client request into
-// the interface reply.
-func DecodeClientResponse(r io.Reader, reply interface{}) error {
-	rawxml, err := ioutil.ReadAll(r)
+// Error satisfies error interface for StatusError.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("xml: unexpected HTTP status %s", e.Status)
+}
+
+// Client is a XML-RPC client calling methods of a single endpoint.
+type Client struct {
+	// URL is the XML-RPC endpoint, e.g. "http://localhost:1234/RPC2".
+	URL string
+	// HTTPClient is used to send requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// MaxResponseSize limits the number of bytes read from a response body.
+	// If zero, DefaultMaxResponseSize is used.
+	MaxResponseSize int64
+	// Options configure encoding of requests and decoding of responses.
+	Options []Option
+}
+
+// NewClient returns a new Client for the endpoint url. If httpClient is nil,
+// http.DefaultClient is used.
+func NewClient(url string, httpClient *http.Client, opts ...Option) *Client {
+	return &Client{URL: url, HTTPClient: httpClient, Options: opts}
+}
+
+// Call invokes the named method with args and decodes the result into reply.
+//
+// args and reply are pointers to structures, as for EncodeClientRequest and
+// DecodeClientResponse. A fault returned by the server is returned as Fault,
+// or FaultError if its code is registered in the FaultRegistry of the
+// options; ctx cancellation aborts the HTTP round-trip.
+func (c *Client) Call(ctx context.Context, method string, args, reply interface{}) error {
+	body, err := EncodeClientRequest(method, args, c.Options...)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	return DecodeClientResponse(resp, reply, c.Options...)
+}
+
+// do posts the encoded request body and returns the response body, limited
+// to MaxResponseSize. The caller must close it.
+func (c *Client) do(ctx context.Context, body []byte) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("Accept", "text/xml, application/xml")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := c.MaxResponseSize
+	if limit <= 0 {
+		limit = DefaultMaxResponseSize
+	}
+	respBody := &limitedBody{ReadCloser: resp.Body, n: limit}
+	if resp.StatusCode != http.StatusOK {
+		defer respBody.Close()
+		return nil, c.statusError(resp, respBody)
+	}
+	if err := checkContentType(resp.Header.Get("Content-Type")); err != nil {
+		respBody.Close()
+		return nil, err
+	}
+	return respBody, nil
+}
+
+// statusError returns the fault in the body of a response with a HTTP status
+// other than 200 OK, or StatusError if the body holds no fault.
+func (c *Client) statusError(resp *http.Response, body io.Reader) error {
+	if checkContentType(resp.Header.Get("Content-Type")) == nil {
+		if ok, err := NewDecoder(body, c.Options...).decodeFaultResponse(); ok {
+			switch err.(type) {
+			case Fault, *FaultError:
+				return err
+			}
+		}
+	}
+	return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+// limitedBody fails with ErrResponseTooLarge once more than n bytes are
+// read from the response body.
+type limitedBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.n -= int64(n)
+	if b.n < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	return n, err
+}
+
+// checkContentType accepts XML media types. An empty Content-Type is
+// tolerated, as some servers don't bother to set it.
+func checkContentType(contentType string) error {
+	if contentType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		return FaultSystemError
+		return fmt.Errorf("xml: invalid response Content-Type %q: %v", contentType, err)
+	}
+	switch mediaType {
+	case "text/xml", "application/xml":
+		return nil
 	}
-	return xml2RPC(string(rawxml), reply)
+	return fmt.Errorf("xml: unexpected response Content-Type %q", contentType)
+}
+
+// EncodeClientRequest encodes parameters for a XML-RPC client request. It is
+// a shorthand for Encoder.EncodeRequest.
+func EncodeClientRequest(method string, args interface{}, opts ...Option) ([]byte, error) {
+	var b bytes.Buffer
+	if err := NewEncoder(&b, opts...).EncodeRequest(method, args); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// DecodeClientResponse decodes the response body of a client request into
+// the interface reply. It is a shorthand for Decoder.Decode.
+func DecodeClientResponse(r io.Reader, reply interface{}, opts ...Option) error {
+	return NewDecoder(r, opts...).Decode(reply)
 }
@@ -0,0 +1,114 @@
+// Copyright 2013 Ivan Danyliuk
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/maddogwg/rpc/v2"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "text/xml")
+	s.RegisterService(new(Service1), "")
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestClientCall(t *testing.T) {
+	ts := newTestServer(t)
+	c := NewClient(ts.URL, nil)
+
+	var res Service1Response
+	if err := c.Call(context.Background(), "Service1.Multiply", &Service1Request{4, 2}, &res); err != nil {
+		t.Fatal("Expected err to be nil, but got:", err)
+	}
+	if res.Result != 8 {
+		t.Errorf("Wrong response: %v.", res.Result)
+	}
+
+	err := c.Call(context.Background(), "Service1.Multiply", &Service1BadRequest{4, 2, 1}, &res)
+	fault, ok := err.(Fault)
+	if !ok {
+		t.Fatal("expected error to be of concrete type Fault, but got", err)
+	}
+	if fault != FaultWrongArgumentsNumber {
+		t.Errorf("wrong fault: %v", fault)
+	}
+}
+
+func TestClientHTTPErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "text/xml; charset=utf-8" {
+			t.Errorf("wrong request Content-Type: %q", ct)
+		}
+		switch r.URL.Path {
+		case "/status":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case "/params":
+			w.Header().Set("Content-Type", "text/xml")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("<methodResponse><params><param><value><int>8</int></value></param></params></methodResponse>"))
+		case "/gateway":
+			w.Header()["Content-Type"] = nil
+			w.WriteHeader(http.StatusBadGateway)
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		case "/large":
+			w.Header().Set("Content-Type", "text/xml")
+			w.Write([]byte("<methodResponse><params><param><value><int>" + strings.Repeat("1", 100) + "</int></value></param></params></methodResponse>"))
+		}
+	}))
+	defer ts.Close()
+
+	var res Service1Response
+	err := NewClient(ts.URL+"/status", nil).Call(context.Background(), "Service1.Multiply", &Service1Request{4, 2}, &res)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected StatusError 503, but got: %v", err)
+	}
+
+	err = NewClient(ts.URL+"/gateway", nil).Call(context.Background(), "Service1.Multiply", &Service1Request{4, 2}, &res)
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected StatusError 502, but got: %v", err)
+	}
+
+	err = NewClient(ts.URL+"/params", nil).Call(context.Background(), "Service1.Multiply", &Service1Request{4, 2}, &res)
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected StatusError 503 for params, but got: %v", err)
+	}
+
+	err = NewClient(ts.URL+"/html", nil).Call(context.Background(), "Service1.Multiply", &Service1Request{4, 2}, &res)
+	if err == nil || !strings.Contains(err.Error(), "Content-Type") {
+		t.Errorf("expected Content-Type error, but got: %v", err)
+	}
+
+	c := NewClient(ts.URL+"/large", nil)
+	c.MaxResponseSize = 64
+	err = c.Call(context.Background(), "Service1.Multiply", &Service1Request{4, 2}, &res)
+	if err != ErrResponseTooLarge {
+		t.Errorf("expected ErrResponseTooLarge, but got: %v", err)
+	}
+}
+
+func TestClientContextCancel(t *testing.T) {
+	ts := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var res Service1Response
+	err := NewClient(ts.URL, nil).Call(ctx, "Service1.Multiply", &Service1Request{4, 2}, &res)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, but got: %v", err)
+	}
+}
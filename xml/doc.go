@@ -15,9 +15,9 @@ Implementation details
 
 The main objective was to use standard encoding/xml package for XML marshalling/unmarshalling. Unfortunately, in current implementation there is no graceful way to implement common structre for marshal and unmarshal functions - marshalling doesn't handle interface{} types so far (though, it could be changed in the future). So, marshalling is implemented manually.
 
-Unmarshalling code first creates temporary structure for unmarshalling XML into, then converts it into the passed variable using reflect package. If XML struct member's name is lowercased, it's first letter will be uppercased, as in Go/Gorilla field name must be exported(first-letter uppercased).
+Unmarshalling code walks the encoding/xml tokens once, through the Decoder type, and fills the passed variable directly using reflect package, without reading the whole body in memory. If XML struct member's name is lowercased, it's first letter will be uppercased, as in Go/Gorilla field name must be exported(first-letter uppercased).
 
-Marshalling code converts rpc directly to the string XML representation.
+Marshalling code writes rpc directly as XML to an io.Writer, through the Encoder type, without building the message in memory. Server responses are streamed into the http.ResponseWriter; the WithResponseCapture option keeps a copy for CodecRequest.ResponseXML, and WithRequestCapture one of requests for CodecRequest.RequestXML.
 
 For the better understanding, I use terms 'rpc2xml' and 'xml2rpc' instead of 'marshal' and 'unmarshall'.
 
@@ -27,16 +27,103 @@ The following types are supported:
 
     XML-RPC             Golang
     -------             ------
-    int, i4             int
-    double              float64
+    int, i4             int, int8-int64, uint-uint64
+    double              float64, float32
     boolean             bool
     stringi             string
     dateTime.iso8601    time.Time
     base64              []byte
-    struct              struct
-    array               []interface{}
+    struct              struct, map[string]T
+    array               []T
     nil                 nil
 
+Values decoded into interface{} get their Go type from the XML-RPC type:
+int becomes int, double float64, struct map[string]interface{}, array
+[]interface{}, nil nil, and so on. This allows decoding arbitrary responses
+without declaring a Go type for them.
+
+Maps with string or encoding.TextMarshaler keys are encoded as structs, with
+members sorted by name.
+
+Types implementing Marshaler and Unmarshaler control their own XML-RPC
+representation: MarshalXMLRPC returns the content of the <value> element and
+UnmarshalXMLRPC parses it back.
+
+Integers of any width are encoded as int, as long as they fit into 32 bits;
+larger values produce an encoding error. When decoding, the value must fit
+into the target field.
+
+All text, including method and member names, is escaped when encoding.
+Characters XML doesn't allow are replaced with U+FFFD, unless the
+WithInvalidChars option strips or rejects them. CDATA sections are decoded
+as text.
+
+Floats are encoded in the shortest decimal form that decodes back to the
+same value; NaN and infinities produce an encoding error. Doubles in exponent
+notation are only decoded with the AllowExponents decoder option.
+
+Times are encoded without their zone, unless the WithDateTimeFormat option
+selects UTC or numeric offsets. When decoding, dateTime.iso8601 values may
+have dashes, a zone, fractional seconds or no time. Values without a zone are
+in time.Local, or the location set with WithDateTimeLocation.
+
+Values that can't be encoded, such as channels, functions, complex numbers
+or overflowing integers, fail with an EncodeError locating the value, e.g.
+"Reply.Items[3].Price". Unexported struct fields are skipped.
+
+Values that can't be decoded fail with a DecodeError giving the path of the
+field, the XML-RPC type found, the Go type expected and the position in the
+input. It wraps a Fault, which errors.As extracts; servers send the fault to
+clients, detailed with the path and position.
+
+Struct members without a matching field are rejected, unless the
+WithUnknownMembers option ignores them or captures them into a map field
+tagged `xml:",any"`.
+
+Decoding is otherwise strict too. The WithDecoderOptions option allows
+integers into float fields, strings into numeric and bool fields, and fewer
+params than fields, and can reject duplicate struct members.
+
+Trailing params whose fields are tagged `xml:",optional"` may be omitted, and
+a last slice field tagged `xml:",variadic"` takes the remaining params. When
+encoding, trailing optional fields left zero are omitted and variadic values
+are sent as separate params.
+
+Apache XML-RPC vendor extensions (i1, i2, i8, float, biginteger and
+bigdecimal) are supported when the WithExtensions option is passed to
+NewCodec, NewClient or the client encoding functions. They map to int8,
+int16, int64, float32, *big.Int and *big.Float respectively.
+
+Errors returned by service methods are sent as FaultApplicationError, unless
+they are, or wrap, a Fault. A FaultRegistry, passed to the codec and the
+client with the WithFaultRegistry option, maps Go errors to fault codes and
+back, so that errors.Is and errors.As work across the wire: clients return
+faults with a registered code as FaultError, wrapping both the Fault and the
+Go error.
+
+Faults are sent with 200 OK. The WithFaultStatus, WithFaultHeaders and
+WithFaultRewrite codec options set another HTTP status per fault code, add
+headers and rewrite faults before they are sent.
+
+Server is a standalone http.Handler serving XML-RPC without gorilla/rpc.
+Services and functions are registered directly, and may take a
+context.Context instead of the *http.Request:
+
+    server := xml.NewServer()
+    server.RegisterService(new(HelloService), "")
+    http.Handle("/RPC2", server)
+
+Functions registered with RegisterFunc may also take the params as
+arguments and return the result, such as
+func(ctx context.Context, a, b int) (int, error).
+
+RecoverHandler wraps a rpc.Server to recover panics of service methods: they
+are logged with their stack trace and the client gets FaultInternalError.
+
+The size of messages, the nesting of values, the length of arrays, structs
+and strings accepted by the decoder are bounded with the WithLimits option.
+Messages exceeding a limit fail with FaultLimitExceeded.
+
 TODO
 
 TODO list:
@@ -5,19 +5,25 @@
 package xml
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 )
 
 // Default Faults
 // NOTE: XMLRPC spec doesn't specify any Fault codes.
 // These codes seems to be widely accepted, and taken from the http://xmlrpc-epi.sourceforge.net/specs/rfc.fault_codes.php
 var (
+	FaultMethodNotFound       = Fault{Code: -32601, String: "Requested method not found"}
 	FaultInvalidParams        = Fault{Code: -32602, String: "Invalid Method Parameters"}
 	FaultWrongArgumentsNumber = Fault{Code: -32602, String: "Wrong Arguments Number"}
 	FaultInternalError        = Fault{Code: -32603, String: "Internal Server Error"}
 	FaultApplicationError     = Fault{Code: -32500, String: "Application Error"}
 	FaultSystemError          = Fault{Code: -32400, String: "System Error"}
 	FaultDecode               = Fault{Code: -32700, String: "Parsing error: not well formed"}
+	FaultLimitExceeded        = Fault{Code: -32705, String: "Parsing error: limit exceeded"}
 )
 
 // Fault represents XML-RPC Fault.
@@ -31,22 +37,129 @@ func (f Fault) Error() string {
 	return fmt.Sprintf("%d: %s", f.Code, f.String)
 }
 
-// Fault2XML is a quick 'marshalling' replacemnt for the Fault case.
-func fault2XML(fault Fault) string {
-	buffer := "<methodResponse><fault>"
-	xml, _ := rpc2XML(fault, false)
-	buffer += xml
-	buffer += "</fault></methodResponse>"
-	return buffer
+// Is reports whether target is a Fault with the same code, whose string is
+// a prefix of the string of f. It lets errors.Is match the default faults,
+// such as FaultInvalidParams, whose string is often detailed.
+func (f Fault) Is(target error) bool {
+	t, ok := target.(Fault)
+	return ok && t.Code == f.Code && strings.HasPrefix(f.String, t.String)
 }
 
-type faultValue struct {
-	Value value `xml:"value"`
+// FaultError is a fault standing for the Go error Err, returned by clients
+// for faults with a code registered in a FaultRegistry. errors.Is and
+// errors.As find both the Fault and Err through it.
+type FaultError struct {
+	Fault Fault
+	Err   error
 }
 
-// IsEmpty returns true if faultValue contain fault.
+// Error satisfies error interface for FaultError.
+func (e *FaultError) Error() string {
+	return e.Fault.Error()
+}
+
+// Unwrap returns Err.
+func (e *FaultError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the fault matches target, see Fault.Is.
+func (e *FaultError) Is(target error) bool {
+	return e.Fault.Is(target)
+}
+
+// As sets target to the fault if it is a *Fault.
+func (e *FaultError) As(target interface{}) bool {
+	if fault, ok := target.(*Fault); ok {
+		*fault = e.Fault
+		return true
+	}
+	return false
+}
+
+// limitFault returns FaultLimitExceeded, detailing the limit exceeded.
+func limitFault(format string, a ...interface{}) Fault {
+	fault := FaultLimitExceeded
+	fault.String += ": " + fmt.Sprintf(format, a...)
+	return fault
+}
+
+// FaultRegistry maps Go errors to fault codes, so that they cross the wire:
+// servers return registered errors as faults with their code, and clients
+// turn faults with a registered code back into the Go error, returned as a
+// FaultError.
 //
-// faultValue should be a struct with 2 members.
-func (f faultValue) IsEmpty() bool {
-	return len(f.Value.Struct) == 0
+// The registry is passed to NewCodec and NewClient with the WithFaultRegistry
+// option.
+type FaultRegistry struct {
+	mutex   sync.RWMutex
+	entries []faultEntry
+}
+
+type faultEntry struct {
+	code int
+	// err is a sentinel error, or typ an error type converted by newErr.
+	err    error
+	typ    reflect.Type
+	newErr func(Fault) error
+}
+
+// NewFaultRegistry returns a new, empty, FaultRegistry.
+func NewFaultRegistry() *FaultRegistry {
+	return &FaultRegistry{}
+}
+
+// Register maps the sentinel error err to code. Errors matching err
+// according to errors.Is are returned as faults with code, and faults with
+// code wrap err.
+func (r *FaultRegistry) Register(code int, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entries = append(r.entries, faultEntry{code: code, err: err})
+}
+
+// RegisterType maps errors of the type of target, such as
+// (*NotFoundError)(nil), to code. Errors matching the type according to
+// errors.As are returned as faults with code, and faults with code are
+// returned as FaultError wrapping the error returned by newErr. If newErr is
+// nil, faults with code are returned as is, which suits servers only
+// returning the errors.
+func (r *FaultRegistry) RegisterType(code int, target error, newErr func(Fault) error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entries = append(r.entries, faultEntry{code: code, typ: reflect.TypeOf(target), newErr: newErr})
+}
+
+// Fault returns the fault for err, and whether err is registered. The
+// string of the fault is the message of err.
+func (r *FaultRegistry) Fault(err error) (Fault, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, entry := range r.entries {
+		if entry.err != nil && errors.Is(err, entry.err) ||
+			entry.typ != nil && errors.As(err, reflect.New(entry.typ).Interface()) {
+			return Fault{Code: entry.code, String: err.Error()}, true
+		}
+	}
+	return Fault{}, false
+}
+
+// Error returns a FaultError wrapping the error registered for the code of
+// fault, or fault as is.
+func (r *FaultRegistry) Error(fault Fault) error {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, entry := range r.entries {
+		if entry.code != fault.Code {
+			continue
+		}
+		if entry.err != nil {
+			return &FaultError{Fault: fault, Err: entry.err}
+		}
+		if entry.newErr != nil {
+			return &FaultError{Fault: fault, Err: entry.newErr(fault)}
+		}
+		return fault
+	}
+	return fault
 }
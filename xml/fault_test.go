@@ -5,7 +5,13 @@
 package xml
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -70,9 +76,8 @@ func TestFaults(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected err to be not nil, but got:", err)
 	}
-	fault, ok = err.(Fault)
-	if !ok {
-		t.Fatal("expected error to be of concrete type Fault, but got", err)
+	if !errors.As(err, &fault) {
+		t.Fatal("expected error to wrap a Fault, but got", err)
 	}
 	if fault.Code != -32602 {
 		t.Errorf("wrong fault code: %d", fault.Code)
@@ -82,3 +87,199 @@ func TestFaults(t *testing.T) {
 		t.Errorf("wrong response: %s", fault.String)
 	}
 }
+
+var ErrFaultTestNotFound = errors.New("not found")
+
+type FaultTestQuotaError struct {
+	Msg string
+}
+
+func (e *FaultTestQuotaError) Error() string {
+	return e.Msg
+}
+
+type FaultTestRegistry struct {
+}
+
+func (t *FaultTestRegistry) Get(r *http.Request, req *FaultTestRequest, res *FaultTestResponse) error {
+	switch req.A {
+	case 1:
+		return fmt.Errorf("item %d: %w", req.B, ErrFaultTestNotFound)
+	case 2:
+		return &FaultTestQuotaError{Msg: "quota exceeded"}
+	}
+	return errors.New("unregistered")
+}
+
+func TestFaultRegistry(t *testing.T) {
+	registry := NewFaultRegistry()
+	registry.Register(404, ErrFaultTestNotFound)
+	registry.RegisterType(429, (*FaultTestQuotaError)(nil), func(f Fault) error {
+		return &FaultTestQuotaError{Msg: f.String}
+	})
+
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(WithFaultRegistry(registry)), "text/xml")
+	s.RegisterService(new(FaultTestRegistry), "")
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	c := NewClient(ts.URL, nil, WithFaultRegistry(registry))
+
+	var res FaultTestResponse
+	err := c.Call(context.Background(), "FaultTestRegistry.Get", &FaultTestRequest{1, 7}, &res)
+	var fault Fault
+	if !errors.As(err, &fault) || fault != (Fault{404, "item 7: not found"}) ||
+		!errors.Is(err, ErrFaultTestNotFound) || !errors.Is(err, Fault{Code: 404}) {
+		t.Error("expected not found fault, but got:", err)
+	}
+
+	err = c.Call(context.Background(), "FaultTestRegistry.Get", &FaultTestRequest{2, 0}, &res)
+	var quota *FaultTestQuotaError
+	if !errors.As(err, &quota) || quota.Msg != "quota exceeded" {
+		t.Error("expected quota error, but got:", err)
+	}
+
+	err = c.Call(context.Background(), "FaultTestRegistry.Get", &FaultTestRequest{3, 0}, &res)
+	if !errors.Is(err, FaultApplicationError) || errors.Is(err, ErrFaultTestNotFound) {
+		t.Error("expected FaultApplicationError, but got:", err)
+	}
+
+	// Without the registry, clients get the plain fault.
+	err = NewClient(ts.URL, nil).Call(context.Background(), "FaultTestRegistry.Get", &FaultTestRequest{1, 7}, &res)
+	if fault, ok := err.(Fault); !ok || fault.Code != 404 || errors.Is(err, ErrFaultTestNotFound) {
+		t.Error("expected plain fault, but got:", err)
+	}
+
+	// Types registered without newErr are returned as faults, which clients
+	// don't convert.
+	registry.RegisterType(430, (*FaultTestQuotaError)(nil), nil)
+	if err := registry.Error(Fault{430, "slow down"}); err != (Fault{430, "slow down"}) {
+		t.Error("expected plain fault, but got:", err)
+	}
+}
+
+func TestFaultIs(t *testing.T) {
+	fault := FaultInvalidParams
+	fault.String += ": fields type mismatch"
+	if !errors.Is(fault, FaultInvalidParams) {
+		t.Error("expected detailed fault to match FaultInvalidParams")
+	}
+	if errors.Is(fault, FaultWrongArgumentsNumber) || errors.Is(FaultInvalidParams, fault) {
+		t.Error("expected faults with other strings not to match")
+	}
+	if !errors.Is(fmt.Errorf("call: %w", fault), FaultInvalidParams) {
+		t.Error("expected wrapped fault to match FaultInvalidParams")
+	}
+}
+
+func TestFaultResponses(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(
+		WithFaultStatus(map[int]int{FaultInvalidParams.Code: http.StatusBadRequest, FaultApplicationError.Code: http.StatusServiceUnavailable}),
+		WithFaultHeaders(func(h http.Header, fault Fault) {
+			if fault.Code == FaultApplicationError.Code {
+				h.Set("Retry-After", "30")
+			}
+		}),
+		WithFaultRewrite(func(fault Fault, err error) Fault {
+			if fault.Code == FaultApplicationError.Code {
+				fault.String = "Application Error"
+			}
+			return fault
+		}),
+	), "text/xml")
+	s.RegisterService(new(FaultTestRegistry), "")
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	body, _ := EncodeClientRequest("FaultTestRegistry.Get", &FaultTestRequest{3, 0})
+	resp, err := http.Post(ts.URL, "text/xml", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal("expected err to be nil, but got:", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "30" {
+		t.Errorf("wrong fault response: %s, Retry-After %q", resp.Status, resp.Header.Get("Retry-After"))
+	}
+
+	// Clients decode faults sent with another status.
+	var res FaultTestResponse
+	c := NewClient(ts.URL, nil)
+	err = c.Call(context.Background(), "FaultTestRegistry.Get", &FaultTestRequest{3, 0}, &res)
+	if fault, ok := err.(Fault); !ok || fault != FaultApplicationError {
+		t.Error("expected rewritten FaultApplicationError, but got:", err)
+	}
+	err = c.Call(context.Background(), "FaultTestRegistry.Get", &struct{ A int }{3}, &res)
+	if fault, ok := err.(Fault); !ok || fault.Code != FaultInvalidParams.Code {
+		t.Error("expected FaultWrongArgumentsNumber, but got:", err)
+	}
+
+	// Other faults are sent with 200 OK.
+	resp, err = http.Post(ts.URL, "text/xml", strings.NewReader("<methodCall><methodName>"))
+	if err != nil {
+		t.Fatal("expected err to be nil, but got:", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Error("expected 200 OK, but got:", resp.Status)
+	}
+}
+
+func TestFaultResponsesMalformed(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(
+		WithFaultStatus(map[int]int{FaultDecode.Code: http.StatusBadRequest}),
+		WithFaultHeaders(func(h http.Header, fault Fault) {
+			h.Set("X-Fault-Code", strconv.Itoa(fault.Code))
+		}),
+		WithFaultRewrite(func(fault Fault, err error) Fault {
+			fault.String = "redacted"
+			return fault
+		}),
+	), "text/xml")
+	s.RegisterService(new(FaultTestRegistry), "")
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL, "text/xml", strings.NewReader("<methodCall><methodName>"))
+	if err != nil {
+		t.Fatal("expected err to be nil, but got:", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("X-Fault-Code") != "-32700" {
+		t.Errorf("wrong fault response: %s, X-Fault-Code %q", resp.Status, resp.Header.Get("X-Fault-Code"))
+	}
+	var res FaultTestResponse
+	err = DecodeClientResponse(resp.Body, &res)
+	if fault, ok := err.(Fault); !ok || fault != (Fault{Code: FaultDecode.Code, String: "redacted"}) {
+		t.Error("expected redacted FaultDecode, but got:", err)
+	}
+}
+
+type FaultTestChars struct {
+}
+
+func (t *FaultTestChars) Get(r *http.Request, req *struct{}, res *FaultTestResponse) error {
+	return errors.New("bad\x01char")
+}
+
+func TestFaultInvalidChars(t *testing.T) {
+	tests := []struct {
+		Policy InvalidChars
+		Fault  Fault
+	}{
+		{StripInvalidChars, Fault{FaultApplicationError.Code, FaultApplicationError.String + ": badchar"}},
+		{RejectInvalidChars, FaultInternalError},
+	}
+	for _, test := range tests {
+		s := rpc.NewServer()
+		s.RegisterCodec(NewCodec(WithInvalidChars(test.Policy)), "text/xml")
+		s.RegisterService(new(FaultTestChars), "")
+
+		var res FaultTestResponse
+		err := executeRaw(s, "<methodCall><methodName>FaultTestChars.Get</methodName><params></params></methodCall>", &res)
+		if fault, ok := err.(Fault); !ok || fault.Code != test.Fault.Code || !strings.HasPrefix(fault.String, test.Fault.String) {
+			t.Errorf("policy %d: expected %v, but got: %v", test.Policy, test.Fault, err)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2013 Ivan Danyliuk
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// Server is a XML-RPC server, serving the methods of registered services and
+// functions without gorilla/rpc.
+//
+// Methods and functions take a context.Context or a *http.Request, then
+// pointers to the args and reply structures, and return an error:
+//
+//	func (s *HelloService) Say(ctx context.Context, args *HelloArgs, reply *HelloReply) error
+//	func (s *HelloService) Say(r *http.Request, args *HelloArgs, reply *HelloReply) error
+//
+// Requests are decoded and responses and faults encoded as by the Codec, and
+// panics are recovered as by RecoverHandler.
+type Server struct {
+	options []Option
+	handler http.Handler
+
+	mutex   sync.RWMutex
+	methods map[string]*serverMethod
+}
+
+// NewServer returns a new Server. opts are the options of the Codec.
+func NewServer(opts ...Option) *Server {
+	s := &Server{options: opts, methods: make(map[string]*serverMethod)}
+	s.handler = RecoverHandler(http.HandlerFunc(s.serve), opts...)
+	return s
+}
+
+// serverMethod is a registered method or function.
+type serverMethod struct {
+	fn reflect.Value
+	// first is the type of the first argument of fn, context.Context or
+	// *http.Request, or nil for positional functions without it.
+	first       reflect.Type
+	args, reply reflect.Type
+	// positional is set for functions taking the params as arguments. args
+	// and reply are then built from the argument and result types.
+	positional bool
+}
+
+// RegisterService registers the exported methods of receiver with a valid
+// signature as "name.Method". If name is empty, the type name of receiver is
+// used.
+func (s *Server) RegisterService(receiver interface{}, name string) error {
+	v := reflect.ValueOf(receiver)
+	if name == "" {
+		name = reflect.Indirect(v).Type().Name()
+	}
+	if name == "" {
+		return fmt.Errorf("xml: no service name for type %s", v.Type())
+	}
+
+	methods := make(map[string]*serverMethod)
+	for i := 0; i < v.NumMethod(); i++ {
+		if v.Type().Method(i).PkgPath != "" {
+			continue
+		}
+		if method, ok := newServerMethod(v.Method(i)); ok {
+			methods[name+"."+v.Type().Method(i).Name] = method
+		}
+	}
+	if len(methods) == 0 {
+		return fmt.Errorf("xml: type %s has no exported methods of suitable type", v.Type())
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for name, method := range methods {
+		s.methods[name] = method
+	}
+	return nil
+}
+
+// RegisterFunc registers the function fn as the method name, e.g.
+// "math.add".
+//
+// fn either has the signature of service methods, or takes the params as
+// arguments, optionally after a context.Context or *http.Request, and returns
+// an error, optionally after a result:
+//
+//	func(ctx context.Context, a, b int) (int, error)
+//
+// Each param is decoded into the argument at its position, with a variadic
+// last argument taking the remaining params, and the result is encoded as
+// the param of the response.
+func (s *Server) RegisterFunc(name string, fn interface{}) error {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return fmt.Errorf("xml: %s is not a function", v.Type())
+	}
+	method, ok := newServerMethod(v)
+	if !ok {
+		method, ok = newPositionalMethod(v)
+	}
+	if !ok {
+		return fmt.Errorf("xml: function %s for %q has no suitable type", v.Type(), name)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.methods[name] = method
+	return nil
+}
+
+// newServerMethod checks the signature of fn, a function or a bound method.
+func newServerMethod(fn reflect.Value) (*serverMethod, bool) {
+	t := fn.Type()
+	if t.NumIn() != 3 || t.NumOut() != 1 || t.Out(0) != typeOfError {
+		return nil, false
+	}
+	if t.In(0) != typeOfContext && t.In(0) != typeOfRequest {
+		return nil, false
+	}
+	args, reply := t.In(1), t.In(2)
+	if args.Kind() != reflect.Ptr || args.Elem().Kind() != reflect.Struct ||
+		reply.Kind() != reflect.Ptr || reply.Elem().Kind() != reflect.Struct {
+		return nil, false
+	}
+	return &serverMethod{fn: fn, first: t.In(0), args: args.Elem(), reply: reply.Elem()}, true
+}
+
+// newPositionalMethod checks the signature of fn, a function taking the
+// params as arguments, and builds the args and reply structures from it.
+func newPositionalMethod(fn reflect.Value) (*serverMethod, bool) {
+	t := fn.Type()
+	if t.NumOut() < 1 || t.NumOut() > 2 || t.Out(t.NumOut()-1) != typeOfError {
+		return nil, false
+	}
+	method := &serverMethod{fn: fn, positional: true}
+	in := 0
+	if t.NumIn() > 0 && (t.In(0) == typeOfContext || t.In(0) == typeOfRequest) {
+		method.first = t.In(0)
+		in = 1
+	}
+
+	var args []reflect.StructField
+	for i := in; i < t.NumIn(); i++ {
+		field := reflect.StructField{Name: fmt.Sprintf("Arg%d", i-in), Type: t.In(i)}
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			field.Tag = `xml:",variadic"`
+		}
+		args = append(args, field)
+	}
+	var reply []reflect.StructField
+	if t.NumOut() == 2 {
+		reply = append(reply, reflect.StructField{Name: "Result", Type: t.Out(0)})
+	}
+	method.args, method.reply = reflect.StructOf(args), reflect.StructOf(reply)
+	return method, true
+}
+
+// call calls the method with the decoded args, setting reply.
+func (m *serverMethod) call(r *http.Request, args, reply reflect.Value) error {
+	var in []reflect.Value
+	switch m.first {
+	case typeOfContext:
+		in = append(in, reflect.ValueOf(r.Context()))
+	case typeOfRequest:
+		in = append(in, reflect.ValueOf(r))
+	}
+	if !m.positional {
+		out := m.fn.Call(append(in, args.Addr(), reply.Addr()))
+		err, _ := out[0].Interface().(error)
+		return err
+	}
+
+	for i := 0; i < args.NumField(); i++ {
+		in = append(in, args.Field(i))
+	}
+	var out []reflect.Value
+	if m.fn.Type().IsVariadic() {
+		out = m.fn.CallSlice(in)
+	} else {
+		out = m.fn.Call(in)
+	}
+	if err, _ := out[len(out)-1].Interface().(error); err != nil {
+		return err
+	}
+	if len(out) == 2 {
+		reply.Field(0).Set(out[0])
+	}
+	return nil
+}
+
+// HasMethod reports whether method is registered.
+func (s *Server) HasMethod(method string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.methods[method]
+	return ok
+}
+
+// ServeHTTP serves a XML-RPC request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "xml: POST method required, received "+r.Method, http.StatusMethodNotAllowed)
+		return
+	}
+
+	decoder := NewDecoder(r.Body, s.options...)
+	codec := &CodecRequest{decoder: decoder, options: s.options}
+	name, err := decoder.DecodeMethodName()
+	if err != nil {
+		codec.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.mutex.RLock()
+	method, ok := s.methods[name]
+	s.mutex.RUnlock()
+	if !ok {
+		fault := FaultMethodNotFound
+		fault.String += fmt.Sprintf(": %q", name)
+		codec.WriteError(w, http.StatusBadRequest, fault)
+		return
+	}
+
+	args := reflect.New(method.args)
+	if err := decoder.Decode(args.Interface()); err != nil {
+		codec.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	reply := reflect.New(method.reply)
+	if err := method.call(r, args.Elem(), reply.Elem()); err != nil {
+		codec.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	codec.WriteResponse(w, reply.Interface())
+}
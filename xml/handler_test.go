@@ -0,0 +1,169 @@
+// Copyright 2013 Ivan Danyliuk
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type contextKey struct{}
+
+type HandlerService struct {
+}
+
+func (t *HandlerService) Multiply(ctx context.Context, req *Service1Request, res *Service1Response) error {
+	res.Result = req.A * req.B * ctx.Value(contextKey{}).(int)
+	return nil
+}
+
+func (t *HandlerService) Fail(r *http.Request, req *struct{}, res *Service1Response) error {
+	return FaultInvalidParams
+}
+
+func (t *HandlerService) Unsuitable(a, b int) int {
+	return a + b
+}
+
+func newHandlerServer(t *testing.T) (*Server, *Client) {
+	s := NewServer()
+	if err := s.RegisterService(new(HandlerService), ""); err != nil {
+		t.Fatal("RegisterService failed:", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, 10)))
+	}))
+	t.Cleanup(ts.Close)
+	return s, NewClient(ts.URL, nil)
+}
+
+func TestServer(t *testing.T) {
+	s, c := newHandlerServer(t)
+	if !s.HasMethod("HandlerService.Multiply") || !s.HasMethod("HandlerService.Fail") || s.HasMethod("HandlerService.Unsuitable") {
+		t.Error("wrong registered methods")
+	}
+
+	var res Service1Response
+	if err := c.Call(context.Background(), "HandlerService.Multiply", &Service1Request{4, 2}, &res); err != nil {
+		t.Fatal("Expected err to be nil, but got:", err)
+	}
+	if res.Result != 80 {
+		t.Errorf("Wrong response: %v.", res.Result)
+	}
+
+	err := c.Call(context.Background(), "HandlerService.Fail", &struct{}{}, &res)
+	if err != FaultInvalidParams {
+		t.Error("expected FaultInvalidParams, but got:", err)
+	}
+	err = c.Call(context.Background(), "HandlerService.Nope", &struct{}{}, &res)
+	if !errors.Is(err, FaultMethodNotFound) {
+		t.Error("expected FaultMethodNotFound, but got:", err)
+	}
+	err = c.Call(context.Background(), "HandlerService.Multiply", &struct{ A int }{4}, &res)
+	if err != FaultWrongArgumentsNumber {
+		t.Error("expected FaultWrongArgumentsNumber, but got:", err)
+	}
+
+	resp, err := http.Get(c.URL)
+	if err != nil {
+		t.Fatal("Expected err to be nil, but got:", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Error("expected 405 for GET, but got:", resp.Status)
+	}
+}
+
+func TestServerRegisterFunc(t *testing.T) {
+	s, c := newHandlerServer(t)
+	err := s.RegisterFunc("math.multiply", func(ctx context.Context, req *Service1Request, res *Service1Response) error {
+		res.Result = req.A * req.B
+		return nil
+	})
+	if err != nil {
+		t.Fatal("RegisterFunc failed:", err)
+	}
+	if err := s.RegisterFunc("math.add", func(a, b int) int { return a + b }); err == nil {
+		t.Error("expected RegisterFunc to reject unsuitable function")
+	}
+	if err := s.RegisterFunc("math.nope", 42); err == nil {
+		t.Error("expected RegisterFunc to reject non-function")
+	}
+
+	var res Service1Response
+	if err := c.Call(context.Background(), "math.multiply", &Service1Request{4, 2}, &res); err != nil {
+		t.Fatal("Expected err to be nil, but got:", err)
+	}
+	if res.Result != 8 {
+		t.Errorf("Wrong response: %v.", res.Result)
+	}
+}
+
+func TestServerPositionalFunc(t *testing.T) {
+	s, c := newHandlerServer(t)
+	funcs := map[string]interface{}{
+		"math.add": func(ctx context.Context, a, b int) (int, error) {
+			return a + b, nil
+		},
+		"math.sum": func(first int, others ...int) (int, error) {
+			for _, n := range others {
+				first += n
+			}
+			return first, nil
+		},
+		"math.check": func(r *http.Request, n int) error {
+			if n < 0 {
+				return FaultInvalidParams
+			}
+			return nil
+		},
+	}
+	for name, fn := range funcs {
+		if err := s.RegisterFunc(name, fn); err != nil {
+			t.Fatal("RegisterFunc failed:", err)
+		}
+	}
+
+	tests := []struct {
+		Method string
+		Args   interface{}
+		Result int
+	}{
+		{"math.add", &struct{ A, B int }{2, 3}, 5},
+		{"math.sum", &struct{ A int }{1}, 1},
+		{"math.sum", &struct{ A, B, C int }{1, 2, 3}, 6},
+	}
+	for _, test := range tests {
+		var res struct{ Result int }
+		if err := c.Call(context.Background(), test.Method, test.Args, &res); err != nil {
+			t.Errorf("%s: expected err to be nil, but got: %v", test.Method, err)
+		} else if res.Result != test.Result {
+			t.Errorf("%s: wrong response: %v.", test.Method, res.Result)
+		}
+	}
+
+	var res struct{}
+	if err := c.Call(context.Background(), "math.check", &struct{ N int }{1}, &res); err != nil {
+		t.Error("expected err to be nil, but got:", err)
+	}
+	if err := c.Call(context.Background(), "math.check", &struct{ N int }{-1}, &res); err != FaultInvalidParams {
+		t.Error("expected FaultInvalidParams, but got:", err)
+	}
+	if err := c.Call(context.Background(), "math.add", &struct{ A int }{2}, &res); err != FaultWrongArgumentsNumber {
+		t.Error("expected FaultWrongArgumentsNumber, but got:", err)
+	}
+	err := c.Call(context.Background(), "math.add", &struct{ A, B string }{"2", "x"}, &res)
+	if e, ok := err.(Fault); !ok || !strings.Contains(e.String, "Args.Arg0") {
+		t.Error("expected fault for Args.Arg0, but got:", err)
+	}
+
+	if err := s.RegisterFunc("math.nope", func(a int) int { return a }); err == nil {
+		t.Error("expected RegisterFunc to reject function without error result")
+	}
+}
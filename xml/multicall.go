@@ -0,0 +1,315 @@
+// Copyright 2013 Ivan Danyliuk
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// rawValue holds the inner XML of a <value> element verbatim. It passes
+// through xml2rpc and rpc2xml conversions untouched, which lets multicall
+// carry heterogeneous params and results.
+type rawValue string
+
+// MarshalXMLRPC implements Marshaler.
+func (r rawValue) MarshalXMLRPC() ([]byte, error) {
+	if r == "" {
+		// An empty <value> is an empty string; don't let it be omitted.
+		return []byte("<string></string>"), nil
+	}
+	return []byte(r), nil
+}
+
+// UnmarshalXMLRPC implements Unmarshaler.
+func (r *rawValue) UnmarshalXMLRPC(data []byte) error {
+	*r = rawValue(data)
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Server side
+// ----------------------------------------------------------------------------
+
+// MulticallArgs are the arguments of system.multicall.
+type MulticallArgs struct {
+	Calls []MulticallCall
+}
+
+// MulticallCall is a single call of a system.multicall request.
+type MulticallCall struct {
+	MethodName string     `xml:"methodName"`
+	Params     []rawValue `xml:"params"`
+}
+
+// MulticallReply is the reply of system.multicall.
+//
+// Each result is either a single-element array holding the method result, or
+// a fault struct.
+type MulticallReply struct {
+	Results []rawValue
+}
+
+// Multicall implements system.multicall.
+//
+// Each call is dispatched through the rpc.Server as a separate request,
+// sharing the context and headers of r. Nested system.multicall calls, by
+// any name or alias, are rejected.
+func (s *SystemService) Multicall(r *http.Request, args *MulticallArgs, reply *MulticallReply) error {
+	reply.Results = make([]rawValue, 0, len(args.Calls))
+	for _, call := range args.Calls {
+		reply.Results = append(reply.Results, s.dispatch(r, call))
+	}
+	return nil
+}
+
+// dispatch serves a single call of a multicall and returns its result. A
+// panic of the call is logged and returned as FaultInternalError, so that
+// the other calls are still served. So is a result failing to encode, without
+// the log.
+func (s *SystemService) dispatch(r *http.Request, call MulticallCall) (result rawValue) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		// WriteResponse aborts responses failing to encode once partly
+		// written: they aren't crashes.
+		if v != http.ErrAbortHandler {
+			logPanic(newOptions(s.codec.options), r.RemoteAddr, v)
+		}
+		result = fault2Raw(FaultInternalError)
+	}()
+	if s.codec.resolve(call.MethodName) == "system.Multicall" {
+		fault := FaultInvalidParams
+		fault.String += ": recursive system.multicall forbidden"
+		return fault2Raw(fault)
+	}
+
+	var body bytes.Buffer
+	body.WriteString("<methodCall><methodName>")
+	xml.EscapeText(&body, []byte(call.MethodName))
+	body.WriteString("</methodName><params>")
+	for _, param := range call.Params {
+		body.WriteString("<param><value>" + string(param) + "</value></param>")
+	}
+	body.WriteString("</params></methodCall>")
+
+	req := r.Clone(r.Context())
+	req.Body = ioutil.NopCloser(&body)
+	req.ContentLength = int64(body.Len())
+
+	w := &multicallResponseWriter{header: make(http.Header)}
+	s.server.ServeHTTP(w, req)
+	return multicallResult(w.body.Bytes())
+}
+
+// multicallResult converts a methodResponse into a multicall result.
+func multicallResult(rawxml []byte) rawValue {
+	params, err := NewDecoder(bytes.NewReader(rawxml)).decodeRaw()
+	if fault, ok := err.(Fault); ok {
+		return fault2Raw(fault)
+	} else if e, ok := err.(*DecodeError); ok {
+		return fault2Raw(e.AsFault())
+	} else if err != nil {
+		fault := FaultInternalError
+		fault.String += ": " + strings.TrimSpace(string(rawxml))
+		return fault2Raw(fault)
+	}
+	out := "<array><data>"
+	for _, param := range params {
+		out += "<value>" + string(param) + "</value>"
+	}
+	out += "</data></array>"
+	return rawValue(out)
+}
+
+// fault2Raw converts fault into a multicall result.
+func fault2Raw(fault Fault) rawValue {
+	xml, _ := rpc2XML(fault, false, &options{})
+	return xml2Raw(xml)
+}
+
+// rawType returns the name of the type element of raw, or an empty string if
+// it has none.
+func rawType(raw rawValue) string {
+	d := xml.NewDecoder(strings.NewReader(string(raw)))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return ""
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			return se.Name.Local
+		}
+	}
+}
+
+// xml2Raw strips the <value> element rpc2XML wraps its output in.
+func xml2Raw(xml string) rawValue {
+	xml = strings.TrimPrefix(xml, "<value>")
+	xml = strings.TrimSuffix(xml, "</value>")
+	return rawValue(xml)
+}
+
+// multicallResponseWriter collects the response of a single multicall call.
+type multicallResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *multicallResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *multicallResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *multicallResponseWriter) WriteHeader(status int) {}
+
+// ----------------------------------------------------------------------------
+// Client side
+// ----------------------------------------------------------------------------
+
+// Batch queues calls to be sent as a single system.multicall request.
+type Batch struct {
+	calls []*BatchCall
+}
+
+// BatchCall is a call queued in a Batch.
+type BatchCall struct {
+	Method string
+	Args   interface{}
+	Reply  interface{}
+	// Error is set when the batch response is decoded: it is the Fault, or
+	// FaultError, returned by the server for this call, or nil on success.
+	Error error
+}
+
+// Add queues a call of method. args and reply are pointers to structures, as
+// for EncodeClientRequest and DecodeClientResponse; either may be nil for
+// methods without params or whose result should be discarded.
+func (b *Batch) Add(method string, args, reply interface{}) *BatchCall {
+	call := &BatchCall{Method: method, Args: args, Reply: reply}
+	b.calls = append(b.calls, call)
+	return call
+}
+
+// Calls returns the queued calls, in order.
+func (b *Batch) Calls() []*BatchCall {
+	return b.calls
+}
+
+// EncodeRequest encodes the queued calls as a system.multicall request.
+func (b *Batch) EncodeRequest(opts ...Option) ([]byte, error) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, opts...)
+	err := e.encode(func() error {
+		e.writeString("<methodCall" + rootAttrs(e.opts) + "><methodName>system.multicall</methodName>" +
+			"<params><param><value><array><data>")
+		for i, call := range b.calls {
+			if err := e.encodeMulticallCall(call); err != nil {
+				return prefixPath(err, fmt.Sprintf("Calls[%d].", i))
+			}
+		}
+		e.writeString("</data></array></value></param></params></methodCall>")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeMulticallCall encodes call as a struct of the multicall array, with
+// the fields of its args as the params.
+func (e *Encoder) encodeMulticallCall(call *BatchCall) error {
+	e.writeString("<value><struct><member><name>methodName</name>")
+	if _, err := e.encodeString(call.Method, false); err != nil {
+		return &EncodeError{Path: "MethodName", Type: reflect.TypeOf(call.Method), Err: err}
+	}
+	e.writeString("</member><member><name>params</name><value><array><data>")
+	if call.Args != nil {
+		for _, p := range params(reflect.ValueOf(call.Args).Elem()) {
+			ok, err := e.encodeValue(p.value, false)
+			if err != nil {
+				return prefixPath(err, "Args."+p.name)
+			}
+			if !ok {
+				// Keep the position of the following params.
+				e.writeString("<value><string></string></value>")
+			}
+		}
+	}
+	e.writeString("</data></array></value></member></struct></value>")
+	return nil
+}
+
+// DecodeResponse decodes a system.multicall response body, filling the reply
+// and setting the Error of each queued call.
+//
+// The returned error reports failures of the batch as a whole, such as a
+// fault returned instead of the results array.
+func (b *Batch) DecodeResponse(r io.Reader, opts ...Option) error {
+	var reply MulticallReply
+	if err := DecodeClientResponse(r, &reply, opts...); err != nil {
+		return err
+	}
+	if len(reply.Results) != len(b.calls) {
+		return fmt.Errorf("xml: system.multicall returned %d results for %d calls",
+			len(reply.Results), len(b.calls))
+	}
+	for i, call := range b.calls {
+		call.Error = decodeMulticallResult(reply.Results[i], call.Reply, opts)
+	}
+	return nil
+}
+
+// decodeMulticallResult decodes a single-element result array into reply, or
+// returns the Fault the result holds.
+func decodeMulticallResult(result rawValue, reply interface{}, opts []Option) error {
+	var ret struct{ Values []rawValue }
+	err := xml2RPC("<methodResponse><params><param><value>"+string(result)+"</value></param></params></methodResponse>", &ret, opts...)
+	if err != nil {
+		if rawType(result) != "struct" {
+			return err
+		}
+		return xml2RPC("<methodResponse><fault><value>"+string(result)+"</value></fault></methodResponse>", &ret, opts...)
+	}
+	if reply == nil {
+		return nil
+	}
+	rawxml := "<methodResponse><params>"
+	for _, item := range ret.Values {
+		rawxml += "<param><value>" + string(item) + "</value></param>"
+	}
+	rawxml += "</params></methodResponse>"
+	return xml2RPC(rawxml, reply, opts...)
+}
+
+// CallBatch sends the calls queued in b as a single system.multicall request.
+//
+// The returned error reports transport failures and failures of the batch as
+// a whole; per-call faults are recorded in the Error of each BatchCall.
+func (c *Client) CallBatch(ctx context.Context, b *Batch) error {
+	body, err := b.EncodeRequest(c.Options...)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	return b.DecodeResponse(resp, c.Options...)
+}
@@ -0,0 +1,123 @@
+// Copyright 2013 Ivan Danyliuk
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSystemMulticall(t *testing.T) {
+	s, sys := newSystemServer(t)
+	sys.codec.RegisterAlias("batch", "system.Multicall")
+
+	calls := []string{
+		"<value><struct><member><name>methodName</name><value>Service1.Multiply</value></member><member><name>params</name><value><array><data><value><int>4</int></value><value><int>2</int></value></data></array></value></member></struct></value>",
+		"<value><struct><member><name>methodName</name><value>multiply</value></member><member><name>params</name><value><array><data><value><int>3</int></value><value><i4>3</i4></value></data></array></value></member></struct></value>",
+		"<value><struct><member><name>methodName</name><value>Service1.Multiply</value></member><member><name>params</name><value><array><data><value><int>3</int></value></data></array></value></member></struct></value>",
+		"<value><struct><member><name>methodName</name><value>Nope.Nope</value></member><member><name>params</name><value><array><data></data></array></value></member></struct></value>",
+		"<value><struct><member><name>methodName</name><value>system.multicall</value></member><member><name>params</name><value><array><data></data></array></value></member></struct></value>",
+		"<value><struct><member><name>methodName</name><value>system.Multicall</value></member><member><name>params</name><value><array><data><value><array><data></data></array></value></data></array></value></member></struct></value>",
+		"<value><struct><member><name>methodName</name><value>batch</value></member><member><name>params</name><value><array><data><value><array><data></data></array></value></data></array></value></member></struct></value>",
+	}
+	body := "<methodCall><methodName>system.multicall</methodName><params><param><value><array><data>" +
+		strings.Join(calls, "") +
+		"</data></array></value></param></params></methodCall>"
+
+	var res MulticallReply
+	if err := executeRaw(s, body, &res); err != nil {
+		t.Fatal("Expected err to be nil, but got:", err)
+	}
+	if len(res.Results) != len(calls) {
+		t.Fatalf("expected %d results, but got: %v", len(calls), res.Results)
+	}
+
+	expected := []string{
+		"<array><data><value><int>8</int></value></data></array>",
+		"<array><data><value><int>9</int></value></data></array>",
+	}
+	for i, result := range expected {
+		if string(res.Results[i]) != result {
+			t.Errorf("result %d: expected %s, but got %s", i, result, res.Results[i])
+		}
+	}
+
+	faults := []int{FaultWrongArgumentsNumber.Code, FaultApplicationError.Code, FaultInvalidParams.Code, FaultInvalidParams.Code, FaultInvalidParams.Code}
+	for i, code := range faults {
+		result := res.Results[len(expected)+i]
+		err := xml2RPC("<methodResponse><fault><value>"+string(result)+"</value></fault></methodResponse>", &struct{}{})
+		if fault, ok := err.(Fault); !ok || fault.Code != code {
+			t.Errorf("result %d: expected fault %d, but got %s", len(expected)+i, code, result)
+		}
+	}
+}
+
+func TestClientCallBatch(t *testing.T) {
+	s, _ := newSystemServer(t)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	var res1, res2 Service1Response
+	var res3 Service3Response
+	var batch Batch
+	call1 := batch.Add("Service1.Multiply", &Service1Request{4, 2}, &res1)
+	call2 := batch.Add("Service1.Multiply", &Service1BadRequest{4, 2, 1}, &res2)
+	call3 := batch.Add("Service3.GetInfo", &Service3Request{Person{Name: "Johnny"}}, &res3)
+	call4 := batch.Add("Nope.Nope", nil, nil)
+
+	if err := NewClient(ts.URL, nil).CallBatch(context.Background(), &batch); err != nil {
+		t.Fatal("Expected err to be nil, but got:", err)
+	}
+
+	if call1.Error != nil || res1.Result != 8 {
+		t.Errorf("call 1: wrong response: %v, %v", res1, call1.Error)
+	}
+	if call2.Error != FaultWrongArgumentsNumber {
+		t.Errorf("call 2: expected FaultWrongArgumentsNumber, but got: %v", call2.Error)
+	}
+	if call3.Error != nil || res3.Info.Twitter != "http://twitter.com/Johnny" {
+		t.Errorf("call 3: wrong response: %v, %v", res3, call3.Error)
+	}
+	if fault, ok := call4.Error.(Fault); !ok || fault.Code != FaultApplicationError.Code {
+		t.Errorf("call 4: expected FaultApplicationError, but got: %v", call4.Error)
+	}
+
+	// Results that are neither arrays nor faults fail with the decoding error.
+	err := decodeMulticallResult("<int>5</int>", &res1, nil)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Found != "int" {
+		t.Errorf("expected DecodeError for int result, but got: %v", err)
+	}
+}
+
+func TestBatchEncodeRequest(t *testing.T) {
+	var batch Batch
+	batch.Add("Service1.Multiply", &Service1Request{4, 2}, nil)
+	batch.Add("Nope.Nope", nil, nil)
+	body, err := batch.EncodeRequest()
+	if err != nil {
+		t.Fatal("Expected err to be nil, but got:", err)
+	}
+	expected := "<methodCall><methodName>system.multicall</methodName><params><param><value><array><data>" +
+		"<value><struct><member><name>methodName</name><value><string>Service1.Multiply</string></value></member>" +
+		"<member><name>params</name><value><array><data><value><int>4</int></value><value><int>2</int></value></data></array></value></member></struct></value>" +
+		"<value><struct><member><name>methodName</name><value><string>Nope.Nope</string></value></member>" +
+		"<member><name>params</name><value><array><data></data></array></value></member></struct></value>" +
+		"</data></array></value></param></params></methodCall>"
+	if string(body) != expected {
+		t.Error("Batch encoding failed")
+		t.Error("Expected", expected)
+		t.Error("Got", string(body))
+	}
+
+	batch.Add("Service1.Multiply", &struct{ C chan int }{}, nil)
+	_, err = batch.EncodeRequest()
+	if e, ok := err.(*EncodeError); !ok || e.Path != "Calls[2].Args.C" {
+		t.Error("expected EncodeError at Calls[2].Args.C, but got:", err)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2013 Ivan Danyliuk
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"net/http"
+	"time"
+)
+
+// Option configures encoding and decoding of XML-RPC messages. Options are
+// accepted by NewCodec, NewClient and the client encoding functions.
+type Option func(*options)
+
+type options struct {
+	extensions   bool
+	limits       Limits
+	decoder      DecoderOptions
+	unknown      UnknownMembers
+	dateTime     DateTimeFormat
+	location     *time.Location
+	invalidChars InvalidChars
+	faults       *FaultRegistry
+	faultStatus  map[int]int
+	faultHeaders func(h http.Header, fault Fault)
+	faultRewrite func(fault Fault, err error) Fault
+	logger       Logger
+
+	captureRequest  bool
+	captureResponse bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithExtensions enables the Apache XML-RPC vendor extensions.
+//
+// When decoding, the <i1>, <i2>, <i8>, <float>, <biginteger> and <bigdecimal>
+// types are accepted, with or without the "ex" namespace prefix. When
+// encoding, int8, int16 and int64 are emitted as <ex:i1>, <ex:i2> and
+// <ex:i8>, other integers not fitting into 32 bits as <ex:i8>, float32 as
+// <ex:float>, and *big.Int and *big.Float as <ex:biginteger> and
+// <ex:bigdecimal>. The "ex" namespace is declared on the root element.
+func WithExtensions() Option {
+	return func(o *options) {
+		o.extensions = true
+	}
+}
+
+// Limits bound the resources spent decoding a message, which matters for
+// untrusted input. A zero field means no limit.
+type Limits struct {
+	// MaxBytes limits the size of the message. It also bounds the memory
+	// used by the XML tokenizer, which buffers text before it is checked
+	// against MaxStringSize.
+	MaxBytes int64
+	// MaxDepth limits the nesting of values within arrays and structs.
+	MaxDepth int
+	// MaxArrayItems limits the number of values of an array.
+	MaxArrayItems int
+	// MaxMembers limits the number of members of a struct.
+	MaxMembers int
+	// MaxStringSize limits the size of the text of a value, such as a
+	// string or the encoded data of a base64.
+	MaxStringSize int
+}
+
+// WithLimits sets the limits checked when decoding. A message exceeding
+// them fails with FaultLimitExceeded.
+func WithLimits(limits Limits) Option {
+	return func(o *options) {
+		o.limits = limits
+	}
+}
+
+// UnknownMembers is the policy for struct members without a matching field.
+type UnknownMembers int
+
+const (
+	// RejectUnknownMembers fails decoding with FaultApplicationError.
+	RejectUnknownMembers UnknownMembers = iota
+	// IgnoreUnknownMembers skips their values.
+	IgnoreUnknownMembers
+	// CaptureUnknownMembers stores them into the field of the structure
+	// tagged `xml:",any"`, a map with string keys. Structures without such
+	// a field reject them.
+	CaptureUnknownMembers
+)
+
+// WithUnknownMembers sets the policy for struct members without a matching
+// field when decoding. Unknown members are rejected by default.
+func WithUnknownMembers(policy UnknownMembers) Option {
+	return func(o *options) {
+		o.unknown = policy
+	}
+}
+
+// DecoderOptions control how lenient decoding is. The zero value is strict:
+// values must match the type of their field, duplicate members overwrite
+// each other and the number of params must match the number of fields.
+// Unknown members are handled by WithUnknownMembers.
+type DecoderOptions struct {
+	// WidenNumbers decodes integers into float fields. Integers of any
+	// width are always accepted by integer fields they fit into.
+	WidenNumbers bool
+	// CoerceStrings decodes strings into integer, float and bool fields,
+	// such as "1" for true.
+	CoerceStrings bool
+	// AllowExponents accepts doubles in exponent notation, such as
+	// "1e-9", which the spec forbids but some servers emit anyway.
+	AllowExponents bool
+	// RejectDuplicateMembers fails decoding structs with the same member
+	// name twice, instead of keeping the last value.
+	RejectDuplicateMembers bool
+	// AllowMissingParams leaves the trailing fields unset when there are
+	// fewer params than fields.
+	AllowMissingParams bool
+}
+
+// WithDecoderOptions sets the leniency of decoding.
+func WithDecoderOptions(opts DecoderOptions) Option {
+	return func(o *options) {
+		o.decoder = opts
+	}
+}
+
+// DateTimeFormat selects how dateTime.iso8601 values are encoded.
+type DateTimeFormat int
+
+const (
+	// DateTimeZoneless encodes times in their own location without the
+	// zone, e.g. "20060102T15:04:05", as most implementations expect.
+	DateTimeZoneless DateTimeFormat = iota
+	// DateTimeUTC encodes times converted to UTC, e.g.
+	// "20060102T15:04:05Z".
+	DateTimeUTC
+	// DateTimeOffset encodes times with their numeric UTC offset, e.g.
+	// "20060102T15:04:05+01:00".
+	DateTimeOffset
+)
+
+// WithDateTimeFormat sets the format of encoded dateTime.iso8601 values.
+// Times are encoded without their zone by default.
+func WithDateTimeFormat(format DateTimeFormat) Option {
+	return func(o *options) {
+		o.dateTime = format
+	}
+}
+
+// WithDateTimeLocation sets the location of decoded dateTime.iso8601 values
+// without a zone. It defaults to time.Local.
+func WithDateTimeLocation(loc *time.Location) Option {
+	return func(o *options) {
+		o.location = loc
+	}
+}
+
+// InvalidChars is the policy for characters XML doesn't allow in text, such
+// as most control characters and invalid UTF-8, when encoding.
+type InvalidChars int
+
+const (
+	// ReplaceInvalidChars replaces them with U+FFFD.
+	ReplaceInvalidChars InvalidChars = iota
+	// StripInvalidChars removes them.
+	StripInvalidChars
+	// RejectInvalidChars fails encoding with an EncodeError.
+	RejectInvalidChars
+)
+
+// WithInvalidChars sets the policy for characters XML doesn't allow in text
+// when encoding. They are replaced with U+FFFD by default.
+func WithInvalidChars(policy InvalidChars) Option {
+	return func(o *options) {
+		o.invalidChars = policy
+	}
+}
+
+// WithFaultRegistry sets the registry mapping Go errors to fault codes. Servers
+// use it to return errors as faults, and clients to turn faults back into
+// errors.
+func WithFaultRegistry(r *FaultRegistry) Option {
+	return func(o *options) {
+		o.faults = r
+	}
+}
+
+// WithFaultStatus sets the HTTP status of fault responses by fault code, for
+// load balancers and monitoring keying on it, e.g. 400 for -32602 and 500
+// for -32603. Faults with other codes are sent with 200 OK, as the spec
+// requires.
+func WithFaultStatus(status map[int]int) Option {
+	return func(o *options) {
+		o.faultStatus = status
+	}
+}
+
+// WithFaultHeaders sets a function adding headers to fault responses, such
+// as Retry-After.
+func WithFaultHeaders(fn func(h http.Header, fault Fault)) Option {
+	return func(o *options) {
+		o.faultHeaders = fn
+	}
+}
+
+// WithFaultRewrite sets a function rewriting faults before they are sent, so
+// that internal details don't leak. err is the error the fault was made
+// from.
+func WithFaultRewrite(fn func(fault Fault, err error) Fault) Option {
+	return func(o *options) {
+		o.faultRewrite = fn
+	}
+}
+
+// WithLogger sets the logger of recovered panics, see RecoverHandler. The
+// standard logger is used by default.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithRequestCapture keeps a copy of the request bodies read by the codec,
+// as they are decoded, for CodecRequest.RequestXML, e.g. to log them. It
+// costs a copy of each request in memory.
+func WithRequestCapture() Option {
+	return func(o *options) {
+		o.captureRequest = true
+	}
+}
+
+// WithResponseCapture keeps a copy of the responses written by the codec, as
+// they are streamed, for CodecRequest.ResponseXML, e.g. to log them. It
+// costs a copy of each response in memory.
+func WithResponseCapture() Option {
+	return func(o *options) {
+		o.captureResponse = true
+	}
+}
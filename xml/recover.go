@@ -0,0 +1,73 @@
+// Copyright 2013 Ivan Danyliuk
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// Logger logs the panics recovered by RecoverHandler and system.multicall.
+// *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// RecoverHandler returns a handler serving h, typically a rpc.Server, that
+// recovers from panics of the service methods. The panic is logged with its
+// stack trace, and the client gets FaultInternalError, so that it can tell
+// crashes apart from transport failures.
+//
+// opts are the options of the codec, such as WithLogger and the fault
+// response options. If the response was already partly written, the
+// connection is aborted instead.
+func RecoverHandler(h http.Handler, opts ...Option) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoverWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			logPanic(newOptions(opts), r.RemoteAddr, v)
+			if rw.written {
+				panic(http.ErrAbortHandler)
+			}
+			codec := &CodecRequest{options: opts}
+			codec.WriteError(w, http.StatusInternalServerError, FaultInternalError)
+		}()
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// logPanic logs v, recovered while serving a request from client, with the
+// stack trace.
+func logPanic(o *options, client string, v interface{}) {
+	logger := o.logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf("xml: panic serving %s: %v\n%s", client, v, debug.Stack())
+}
+
+// recoverWriter records whether the response was started.
+type recoverWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *recoverWriter) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recoverWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
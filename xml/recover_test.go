@@ -0,0 +1,95 @@
+// Copyright 2013 Ivan Danyliuk
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/maddogwg/rpc/v2"
+)
+
+type PanicService struct {
+}
+
+func (t *PanicService) Crash(r *http.Request, req *struct{}, res *struct{ Result int }) error {
+	var m map[string]int
+	m["crash"] = 1
+	return nil
+}
+
+func (t *PanicService) Partial(r *http.Request, req *struct{}, res *struct{ Items []interface{} }) error {
+	res.Items = []interface{}{strings.Repeat("a", 1<<16), math.NaN()}
+	return nil
+}
+
+func TestRecoverHandler(t *testing.T) {
+	var logs bytes.Buffer
+	opts := []Option{WithLogger(log.New(&logs, "", 0))}
+	s := rpc.NewServer()
+	codec := NewCodec(opts...)
+	s.RegisterCodec(codec, "text/xml")
+	sys, err := codec.RegisterSystemService(s)
+	if err != nil {
+		t.Fatal("RegisterSystemService failed:", err)
+	}
+	sys.RegisterService(new(PanicService), "")
+	sys.RegisterService(new(Service1), "")
+	ts := httptest.NewServer(RecoverHandler(s, opts...))
+	defer ts.Close()
+	c := NewClient(ts.URL, nil)
+
+	var res struct{ Result int }
+	err = c.Call(context.Background(), "PanicService.Crash", &struct{}{}, &res)
+	if _, ok := err.(Fault); !ok || !errors.Is(err, FaultInternalError) {
+		t.Error("expected FaultInternalError, but got:", err)
+	}
+	if !strings.Contains(logs.String(), "assignment to entry in nil map") || !strings.Contains(logs.String(), "PanicService") {
+		t.Error("expected panic to be logged with its stack, but got:", logs.String())
+	}
+
+	// The server keeps serving.
+	var mul Service1Response
+	if err := c.Call(context.Background(), "Service1.Multiply", &Service1Request{4, 2}, &mul); err != nil || mul.Result != 8 {
+		t.Error("expected call to succeed after a panic, but got:", err)
+	}
+
+	// A panic within a multicall only fails its call.
+	logs.Reset()
+	var b Batch
+	crash := b.Add("PanicService.Crash", &struct{}{}, &res)
+	multiply := b.Add("Service1.Multiply", &Service1Request{4, 3}, &mul)
+	if err := c.CallBatch(context.Background(), &b); err != nil {
+		t.Fatal("expected batch to succeed, but got:", err)
+	}
+	if !errors.Is(crash.Error, FaultInternalError) || multiply.Error != nil || mul.Result != 12 {
+		t.Error("wrong batch results:", crash.Error, multiply.Error, mul.Result)
+	}
+	if !strings.Contains(logs.String(), "assignment to entry in nil map") {
+		t.Error("expected multicall panic to be logged, but got:", logs.String())
+	}
+
+	// A result failing to encode once partly written isn't logged.
+	logs.Reset()
+	b = Batch{}
+	partial := b.Add("PanicService.Partial", &struct{}{}, nil)
+	multiply = b.Add("Service1.Multiply", &Service1Request{4, 3}, &mul)
+	if err := c.CallBatch(context.Background(), &b); err != nil {
+		t.Fatal("expected batch to succeed, but got:", err)
+	}
+	if !errors.Is(partial.Error, FaultInternalError) || multiply.Error != nil {
+		t.Error("wrong batch results:", partial.Error, multiply.Error)
+	}
+	if logs.Len() != 0 {
+		t.Error("expected encoding failure not to be logged, but got:", logs.String())
+	}
+}
@@ -5,181 +5,741 @@
 package xml
 
 import (
+	"bufio"
+	"bytes"
+	"encoding"
 	"encoding/base64"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
 	"reflect"
-	"strings"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
-func rpcRequest2XML(method string, rpc interface{}) (string, error) {
-	buffer := "<methodCall><methodName>"
-	buffer += method
-	buffer += "</methodName>"
-	params, err := rpcParams2XML(rpc)
-	buffer += params
-	buffer += "</methodCall>"
-	return buffer, err
+// extensionsNamespace is the namespace of the Apache XML-RPC vendor
+// extensions, declared with the "ex" prefix when extensions are enabled.
+const extensionsNamespace = "http://ws.apache.org/xmlrpc/namespaces/extensions"
+
+var (
+	typeOfBigInt   = reflect.TypeOf(big.Int{})
+	typeOfBigFloat = reflect.TypeOf(big.Float{})
+)
+
+// writerPool recycles the buffers encoders write through.
+var writerPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewWriterSize(nil, 4096)
+	},
+}
+
+// ----------------------------------------------------------------------------
+// Encoder
+// ----------------------------------------------------------------------------
+
+// EncodeError is returned when a value can't be encoded, such as an integer
+// overflowing the XML-RPC int or a channel.
+type EncodeError struct {
+	// Path locates the value, e.g. "Reply.Items[3].Price". The root is
+	// "Args" for requests and "Reply" for responses.
+	Path string
+	Type reflect.Type
+	Err  error
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("xml: cannot encode %s of type %s: %v", e.Path, e.Type, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// prefixPath prepends prefix to the path of an EncodeError or DecodeError.
+func prefixPath(err error, prefix string) error {
+	switch e := err.(type) {
+	case *EncodeError:
+		e.Path = prefix + e.Path
+	case *DecodeError:
+		e.Path = prefix + e.Path
+	}
+	return err
+}
+
+// Encoder writes XML-RPC messages to an output stream.
+//
+// Messages are written through a pooled buffer as they are encoded, without
+// building them in memory first. When encoding fails, the output written so
+// far is incomplete; nothing is written if the message is small enough to
+// fit into the buffer.
+type Encoder struct {
+	w    io.Writer
+	opts *options
+
+	buf *bufio.Writer
+	// pending holds the start tags of structs and members, which are only
+	// written once some content follows since empty structs are omitted.
+	pending []byte
+	scratch [64]byte
+	// written is set once output reaches w.
+	written bool
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer, opts ...Option) *Encoder {
+	return &Encoder{w: w, opts: newOptions(opts)}
+}
+
+// EncodeRequest writes a methodCall of method. args is a pointer to a
+// structure, whose fields are encoded as the params.
+func (e *Encoder) EncodeRequest(method string, args interface{}) error {
+	return e.encode(func() error {
+		name, err := e.appendText(e.scratch[:0], method)
+		if err != nil {
+			return &EncodeError{Path: "MethodName", Type: reflect.TypeOf(method), Err: err}
+		}
+		e.writeString("<methodCall" + rootAttrs(e.opts) + "><methodName>")
+		e.buf.Write(name)
+		e.buf.WriteString("</methodName>")
+		if err := e.encodeParams("Args", args); err != nil {
+			return err
+		}
+		e.writeString("</methodCall>")
+		return nil
+	})
+}
+
+// EncodeResponse writes a methodResponse. reply is a pointer to a structure,
+// whose fields are encoded as the params.
+func (e *Encoder) EncodeResponse(reply interface{}) error {
+	return e.encode(func() error {
+		e.writeString("<methodResponse" + rootAttrs(e.opts) + ">")
+		if err := e.encodeParams("Reply", reply); err != nil {
+			return err
+		}
+		e.writeString("</methodResponse>")
+		return nil
+	})
+}
+
+// EncodeFault writes a methodResponse holding fault.
+func (e *Encoder) EncodeFault(fault Fault) error {
+	return e.encode(func() error {
+		e.writeString("<methodResponse><fault>")
+		if _, err := e.encodeValue(reflect.ValueOf(fault), false); err != nil {
+			return prefixPath(err, "Fault")
+		}
+		e.writeString("</fault></methodResponse>")
+		return nil
+	})
+}
+
+// encode runs fn with a pooled buffer, and flushes it unless fn fails.
+func (e *Encoder) encode(fn func() error) error {
+	e.written = false
+	e.buf = writerPool.Get().(*bufio.Writer)
+	e.buf.Reset(writerFunc(func(p []byte) (int, error) {
+		e.written = true
+		return e.w.Write(p)
+	}))
+	e.pending = e.pending[:0]
+	defer func() {
+		e.buf.Reset(nil)
+		writerPool.Put(e.buf)
+		e.buf = nil
+	}()
+
+	if err := fn(); err != nil {
+		return err
+	}
+	return e.buf.Flush()
+}
+
+// rootAttrs returns the attributes of the root element.
+func rootAttrs(opts *options) string {
+	if opts.extensions {
+		return ` xmlns:ex="` + extensionsNamespace + `"`
+	}
+	return ""
+}
+
+func (e *Encoder) encodeParams(root string, rpc interface{}) error {
+	e.writeString("<params>")
+	for _, p := range params(reflect.ValueOf(rpc).Elem()) {
+		e.writeString("<param>")
+		if _, err := e.encodeValue(p.value, false); err != nil {
+			return prefixPath(err, root+"."+p.name)
+		}
+		e.writeString("</param>")
+	}
+	e.writeString("</params>")
+	return nil
+}
+
+// param is a value encoded as a param, and its path.
+type param struct {
+	name  string
+	value reflect.Value
+}
+
+// params returns the params of an args structure: its fields, without the
+// trailing optional fields left zero, and with the values of the variadic
+// field, see paramLayout.
+func params(v reflect.Value) []param {
+	required, variadic := paramLayout(v.Type())
+	n := v.NumField()
+	if variadic >= 0 {
+		n = variadic
+	}
+	if variadic < 0 || v.Field(variadic).Len() == 0 {
+		for n > required && v.Field(n-1).IsZero() {
+			n--
+		}
+	}
+
+	params := make([]param, 0, n)
+	for i := 0; i < n; i++ {
+		params = append(params, param{v.Type().Field(i).Name, v.Field(i)})
+	}
+	if variadic >= 0 {
+		rest := v.Field(variadic)
+		for i := 0; i < rest.Len(); i++ {
+			params = append(params, param{fmt.Sprintf("%s[%d]", v.Type().Field(variadic).Name, i), rest.Index(i)})
+		}
+	}
+	return params
+}
+
+// flushPending writes the start tags held back so far.
+func (e *Encoder) flushPending() {
+	if len(e.pending) > 0 {
+		e.buf.Write(e.pending)
+		e.pending = e.pending[:0]
+	}
+}
+
+func (e *Encoder) writeString(s string) {
+	e.flushPending()
+	e.buf.WriteString(s)
+}
+
+// writeElement writes content enclosed in a tag element, within a <value>.
+func (e *Encoder) writeElement(tag string, content []byte) {
+	e.writeString("<value><" + tag + ">")
+	e.buf.Write(content)
+	e.buf.WriteString("</" + tag + "></value>")
 }
 
-func rpcResponse2XML(rpc interface{}) (string, error) {
-	buffer := "<methodResponse>"
-	params, err := rpcParams2XML(rpc)
-	buffer += params
-	buffer += "</methodResponse>"
-	return buffer, err
+// ----------------------------------------------------------------------------
+// Values
+// ----------------------------------------------------------------------------
+
+// Marshaler is the interface implemented by types that can marshal
+// themselves into a XML-RPC value.
+//
+// MarshalXMLRPC returns the content of the <value> element, such as
+// "<string>12.50 EUR</string>". Returning no content omits the value, as for
+// empty fields tagged with omitempty.
+type Marshaler interface {
+	MarshalXMLRPC() ([]byte, error)
+}
+
+var typeOfMarshaler = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+// encodeValue writes v as a <value> element, and reports whether it did. The
+// value is omitted when it is empty and omitEmpty is set, when it is a nil
+// interface, and when it is a struct without any member to encode.
+func (e *Encoder) encodeValue(v reflect.Value, omitEmpty bool) (bool, error) {
+	for v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return false, nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return false, nil
+	}
+
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		if omitEmpty {
+			return false, nil
+		}
+		e.writeString("<value><nil/></value>")
+		return true, nil
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if _, ok := marshaler(v); !ok {
+			// Omission only applies to indirect value when pointer is nil;
+			// no need to propagate omitEmpty at this point.
+			return e.encodeValue(v.Elem(), false)
+		}
+	}
+
+	wrote, err := e.encodeType(v, omitEmpty)
+	if _, ok := err.(*EncodeError); err != nil && !ok {
+		err = &EncodeError{Type: v.Type(), Err: err}
+	}
+	return wrote, err
 }
 
-func rpcParams2XML(rpc interface{}) (string, error) {
-	var err error
-	buffer := "<params>"
-	for i := 0; i < reflect.ValueOf(rpc).Elem().NumField(); i++ {
-		var xml string
-		buffer += "<param>"
-		xml, err = rpc2XML(reflect.ValueOf(rpc).Elem().Field(i).Interface(), false)
-		buffer += xml
-		buffer += "</param>"
+func (e *Encoder) encodeType(v reflect.Value, omitEmpty bool) (bool, error) {
+	if m, ok := marshaler(v); ok {
+		return e.encodeMarshaler(m)
+	}
+	switch v.Type() {
+	case typeOfBigInt:
+		value := v.Interface().(big.Int)
+		return e.encodeBigInt(&value)
+	case typeOfBigFloat:
+		value := v.Interface().(big.Float)
+		return e.encodeBigFloat(&value)
+	case typeOfTime:
+		e.encodeTime(v.Interface().(time.Time))
+		return true, nil
+	}
+	return e.encodeKind(v, omitEmpty)
+}
+
+// marshaler returns the Marshaler implemented by v, or by a pointer to a
+// copy of v when MarshalXMLRPC has a pointer receiver.
+func marshaler(v reflect.Value) (Marshaler, bool) {
+	if v.Type().Implements(typeOfMarshaler) {
+		return v.Interface().(Marshaler), true
+	}
+	if v.Kind() != reflect.Ptr && reflect.PtrTo(v.Type()).Implements(typeOfMarshaler) {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return p.Interface().(Marshaler), true
 	}
-	buffer += "</params>"
-	return buffer, err
+	return nil, false
 }
 
-func rpc2XML(value interface{}, omitEmpty bool) (string, error) {
-	var out string
+// encodeMarshaler calls the Marshaler and checks its output is well-formed
+// before writing it.
+func (e *Encoder) encodeMarshaler(m Marshaler) (bool, error) {
+	b, err := m.MarshalXMLRPC()
+	if err != nil {
+		return false, err
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		if _, err := decoder.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			return false, fmt.Errorf("invalid XML from MarshalXMLRPC: %v", err)
+		}
+	}
+	if len(b) == 0 {
+		return false, nil
+	}
+	e.writeString("<value>")
+	e.buf.Write(b)
+	e.buf.WriteString("</value>")
+	return true, nil
+}
 
-	switch reflect.ValueOf(value).Kind() {
-	case reflect.Int:
-		out = int2XML(value.(int), omitEmpty)
+func (e *Encoder) encodeKind(v reflect.Value, omitEmpty bool) (bool, error) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return e.encodeInt(v.Int(), v.Kind(), omitEmpty)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return e.encodeUint(v.Uint(), omitEmpty)
+	case reflect.Float32:
+		if e.opts.extensions {
+			return e.encodeFloat("ex:float", v.Float(), 32, omitEmpty)
+		}
+		return e.encodeFloat("double", v.Float(), 32, omitEmpty)
 	case reflect.Float64:
-		out = double2XML(value.(float64), omitEmpty)
+		return e.encodeFloat("double", v.Float(), 64, omitEmpty)
 	case reflect.String:
-		out = string2XML(value.(string), omitEmpty)
+		return e.encodeString(v.String(), omitEmpty)
 	case reflect.Bool:
-		out = bool2XML(value.(bool), omitEmpty)
+		return e.encodeBool(v.Bool(), omitEmpty), nil
 	case reflect.Struct:
-		if reflect.TypeOf(value).String() != "time.Time" {
-			out = struct2XML(value, omitEmpty)
-		} else {
-			out = time2XML(value.(time.Time))
-		}
+		return e.encodeStruct(v)
 	case reflect.Slice, reflect.Array:
-		// FIXME: is it the best way to recognize '[]byte'?
-		if reflect.TypeOf(value).String() != "[]uint8" {
-			out = array2XML(value, omitEmpty)
-		} else {
-			out = base642XML(value.([]byte))
-		}
-	case reflect.Ptr:
-		if reflect.ValueOf(value).IsNil() {
-			if !omitEmpty {
-				out = "<nil/>"
-			}
-		} else {
-			// Omission only applies to indirect value when pointer is nil; no
-			// need to propagate omitEmpty at this point.
-			return rpc2XML(reflect.Indirect(reflect.ValueOf(value)).Interface(), false)
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeBase64(v.Bytes())
+			return true, nil
+		}
+		return e.encodeArray(v, omitEmpty)
+	case reflect.Map:
+		return e.encodeMap(v, omitEmpty)
+	}
+	return false, errors.New("unsupported type")
+}
+
+// encodeInt encodes integers of any width, as long as they fit into the
+// 32-bit XML-RPC int. With extensions enabled, int8, int16 and int64 kinds
+// are encoded as <ex:i1>, <ex:i2> and <ex:i8>, and so are larger values of
+// other kinds.
+func (e *Encoder) encodeInt(value int64, kind reflect.Kind, omitEmpty bool) (bool, error) {
+	if omitEmpty && value == 0 {
+		return false, nil
+	}
+	tag := "int"
+	if e.opts.extensions {
+		switch {
+		case kind == reflect.Int8:
+			tag = "ex:i1"
+		case kind == reflect.Int16:
+			tag = "ex:i2"
+		case kind == reflect.Int64, value < math.MinInt32, value > math.MaxInt32:
+			tag = "ex:i8"
 		}
 	}
+	if tag == "int" && (value < math.MinInt32 || value > math.MaxInt32) {
+		return false, fmt.Errorf("integer %d overflows 32-bit XML-RPC int", value)
+	}
+	e.writeElement(tag, strconv.AppendInt(e.scratch[:0], value, 10))
+	return true, nil
+}
 
-	if out != "" {
-		return "<value>" + out + "</value>", nil
+func (e *Encoder) encodeUint(value uint64, omitEmpty bool) (bool, error) {
+	if e.opts.extensions && value > math.MaxInt64 {
+		return false, fmt.Errorf("integer %d overflows 64-bit XML-RPC ex:i8", value)
+	}
+	if !e.opts.extensions && value > math.MaxInt32 {
+		return false, fmt.Errorf("integer %d overflows 32-bit XML-RPC int", value)
 	}
-	return "", nil
+	return e.encodeInt(int64(value), reflect.Uint, omitEmpty)
 }
 
-func int2XML(value int, omitEmpty bool) string {
+// encodeFloat encodes a float of the given bit size in the shortest decimal
+// form that parses back to the same value, without exponent as the spec
+// requires. NaN and infinities can't be represented.
+func (e *Encoder) encodeFloat(tag string, value float64, bits int, omitEmpty bool) (bool, error) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return false, fmt.Errorf("%v is not a valid XML-RPC double", value)
+	}
 	if omitEmpty && value == 0 {
-		return ""
+		return false, nil
 	}
-	return fmt.Sprintf("<int>%d</int>", value)
+	e.writeElement(tag, strconv.AppendFloat(e.scratch[:0], value, 'f', -1, bits))
+	return true, nil
 }
 
-func double2XML(value float64, omitEmpty bool) string {
-	if omitEmpty && value == 0 {
-		return ""
+func (e *Encoder) encodeBigInt(value *big.Int) (bool, error) {
+	if !e.opts.extensions {
+		return false, errors.New("big integers require extensions")
+	}
+	e.writeElement("ex:biginteger", value.Append(e.scratch[:0], 10))
+	return true, nil
+}
+
+func (e *Encoder) encodeBigFloat(value *big.Float) (bool, error) {
+	if !e.opts.extensions {
+		return false, errors.New("big decimals require extensions")
 	}
-	return fmt.Sprintf("<double>%f</double>", value)
+	e.writeElement("ex:bigdecimal", value.Append(e.scratch[:0], 'f', -1))
+	return true, nil
 }
 
-func bool2XML(value bool, omitEmpty bool) string {
+func (e *Encoder) encodeBool(value bool, omitEmpty bool) bool {
 	if omitEmpty && !value {
-		return ""
+		return false
 	}
-	var b string
 	if value {
-		b = "1"
+		e.writeString("<value><boolean>1</boolean></value>")
 	} else {
-		b = "0"
+		e.writeString("<value><boolean>0</boolean></value>")
 	}
-	return fmt.Sprintf("<boolean>%s</boolean>", b)
+	return true
 }
 
-func string2XML(value string, omitEmpty bool) string {
+func (e *Encoder) encodeString(value string, omitEmpty bool) (bool, error) {
 	if omitEmpty && value == "" {
-		return ""
-	}
-	value = strings.Replace(value, "&", "&amp;", -1)
-	value = strings.Replace(value, "\"", "&quot;", -1)
-	value = strings.Replace(value, "<", "&lt;", -1)
-	value = strings.Replace(value, ">", "&gt;", -1)
-	return fmt.Sprintf("<string>%s</string>", value)
-}
-
-func struct2XML(value interface{}, omitEmpty bool) string {
-	out := ""
-	for i := 0; i < reflect.TypeOf(value).NumField(); i++ {
-		field := reflect.ValueOf(value).Field(i)
-		field_type := reflect.TypeOf(value).Field(i)
-		var name string = field_type.Name
-		field_tag := parseXMLTag(field_type)
-		field_value, _ := rpc2XML(field.Interface(), field_tag.OmitEmpty)
-		if field_value == "" {
+		return false, nil
+	}
+	e.writeString("<value><string>")
+	if err := e.writeText(value); err != nil {
+		return false, err
+	}
+	e.buf.WriteString("</string></value>")
+	return true, nil
+}
+
+// appendText appends s to b escaped as XML text. Characters XML doesn't
+// allow are handled according to the InvalidChars policy of the options.
+func (e *Encoder) appendText(b []byte, s string) ([]byte, error) {
+	last := 0
+	for i := 0; i < len(s); {
+		esc, size, escaped, err := e.escapeRune(s, i)
+		if err != nil {
+			return b, err
+		}
+		if escaped {
+			b = append(b, s[last:i]...)
+			b = append(b, esc...)
+			last = i + size
+		}
+		i += size
+	}
+	return append(b, s[last:]...), nil
+}
+
+// writeText writes s escaped as by appendText straight to the buffer, so
+// that long strings aren't copied first.
+func (e *Encoder) writeText(s string) error {
+	last := 0
+	for i := 0; i < len(s); {
+		esc, size, escaped, err := e.escapeRune(s, i)
+		if err != nil {
+			return err
+		}
+		if escaped {
+			e.buf.WriteString(s[last:i])
+			e.buf.WriteString(esc)
+			last = i + size
+		}
+		i += size
+	}
+	e.buf.WriteString(s[last:])
+	return nil
+}
+
+// escapeRune returns the size of the rune at byte i of s, and its escape if
+// it isn't written as is.
+func (e *Encoder) escapeRune(s string, i int) (string, int, bool, error) {
+	r, size := utf8.DecodeRuneInString(s[i:])
+	switch {
+	case r == '&':
+		return "&amp;", size, true, nil
+	case r == '<':
+		return "&lt;", size, true, nil
+	case r == '>':
+		return "&gt;", size, true, nil
+	case r == '"':
+		return "&quot;", size, true, nil
+	case r == '\r':
+		// Escaped, or XML parsers would normalize it into \n.
+		return "&#xD;", size, true, nil
+	case r == utf8.RuneError && size == 1, !isXMLChar(r):
+		switch e.opts.invalidChars {
+		case RejectInvalidChars:
+			return "", size, false, fmt.Errorf("invalid XML character %q at byte %d", s[i:i+size], i)
+		case StripInvalidChars:
+			return "", size, true, nil
+		}
+		return "\uFFFD", size, true, nil
+	}
+	return "", size, false, nil
+}
+
+// isXMLChar reports whether r is allowed in XML text, see
+// https://www.w3.org/TR/xml/#charsets.
+func isXMLChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
+
+// encodeStruct encodes the fields of v as members. The start tags are held
+// back until the first member is written, so that a struct without members
+// is omitted.
+func (e *Encoder) encodeStruct(v reflect.Value) (bool, error) {
+	mark := len(e.pending)
+	e.pending = append(e.pending, "<value><struct>"...)
+	wrote := false
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" {
+			// Unexported fields are not encoded.
+			continue
+		}
+		tag := parseXMLTag(field)
+		if tag.Any && field.Type.Kind() == reflect.Map {
+			// Members captured when decoding are encoded inline.
+			ok, err := e.encodeMembers(v.Field(i))
+			if err != nil {
+				return false, prefixPath(err, "."+field.Name)
+			}
+			wrote = wrote || ok
+			continue
+		}
+		name := field.Name
+		if tag.Name != "" {
+			name = tag.Name
+		}
+
+		memberMark := len(e.pending)
+		e.pending = append(e.pending, "<member><name>"...)
+		pending, err := e.appendText(e.pending, name)
+		if err != nil {
+			return false, prefixPath(&EncodeError{Type: field.Type, Err: err}, "."+field.Name)
+		}
+		e.pending = append(pending, "</name>"...)
+		ok, err := e.encodeValue(v.Field(i), tag.OmitEmpty)
+		if err != nil {
+			return false, prefixPath(err, "."+field.Name)
+		}
+		if !ok {
+			e.pending = e.pending[:memberMark]
 			continue
 		}
-		if field_tag.Name != "" {
-			name = field_tag.Name
+		e.buf.WriteString("</member>")
+		wrote = true
+	}
+	if !wrote {
+		e.pending = e.pending[:mark]
+		return false, nil
+	}
+	e.buf.WriteString("</struct></value>")
+	return true, nil
+}
+
+// encodeMap encodes maps with string or encoding.TextMarshaler keys as
+// structs. Members are sorted by name, so the output is deterministic.
+func (e *Encoder) encodeMap(v reflect.Value, omitEmpty bool) (bool, error) {
+	if omitEmpty && v.Len() == 0 {
+		return false, nil
+	}
+
+	e.writeString("<value><struct>")
+	if _, err := e.encodeMembers(v); err != nil {
+		return false, err
+	}
+	e.writeString("</struct></value>")
+	return true, nil
+}
+
+// encodeMembers encodes the entries of a map as members, sorted by name, and
+// reports whether any was written.
+func (e *Encoder) encodeMembers(v reflect.Value) (bool, error) {
+	type mapMember struct {
+		name  string
+		value reflect.Value
+	}
+	members := make([]mapMember, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		name, err := mapKey2String(iter.Key())
+		if err != nil {
+			return false, err
 		}
-		field_name := fmt.Sprintf("<name>%s</name>", name)
-		out += fmt.Sprintf("<member>%s%s</member>", field_name, field_value)
+		members = append(members, mapMember{name, iter.Value()})
 	}
-	if out == "" {
-		return ""
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].name < members[j].name
+	})
+
+	wrote := false
+	for _, m := range members {
+		memberMark := len(e.pending)
+		e.pending = append(e.pending, "<member><name>"...)
+		pending, err := e.appendText(e.pending, m.name)
+		if err != nil {
+			return false, fmt.Errorf("member name: %v", err)
+		}
+		e.pending = append(pending, "</name>"...)
+		if isNilValue(m.value) {
+			e.writeString("<value><nil/></value>")
+		} else if ok, err := e.encodeValue(m.value, false); err != nil {
+			return false, prefixPath(err, fmt.Sprintf("[%q]", m.name))
+		} else if !ok {
+			e.pending = e.pending[:memberMark]
+			continue
+		}
+		e.buf.WriteString("</member>")
+		wrote = true
 	}
-	return "<struct>" + out + "</struct>"
+	return wrote, nil
 }
 
-func array2XML(value interface{}, omitEmpty bool) (out string) {
-	if omitEmpty && reflect.ValueOf(value).Len() == 0 {
-		out = ""
-		return
+func mapKey2String(key reflect.Value) (string, error) {
+	if key.Kind() == reflect.String {
+		return key.String(), nil
 	}
-	out = "<array><data>"
-	for i := 0; i < reflect.ValueOf(value).Len(); i++ {
-		item_xml, _ := rpc2XML(reflect.ValueOf(value).Index(i).Interface(), false)
-		out += item_xml
+	if tm, ok := key.Interface().(encoding.TextMarshaler); ok {
+		text, err := tm.MarshalText()
+		return string(text), err
 	}
-	out += "</data></array>"
-	return
+	return "", fmt.Errorf("unsupported map key type %s", key.Type())
 }
 
-func time2XML(t time.Time) string {
-	/*
-		// TODO: find out whether we need to deal
-		// here with TZ
-		var tz string;
-		zone, offset := t.Zone()
-		if zone == "UTC" {
-			tz = "Z"
-		} else {
-			tz = fmt.Sprintf("%03d00", offset / 3600 )
+// isNilValue reports whether v is a nil interface or pointer.
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+func (e *Encoder) encodeArray(v reflect.Value, omitEmpty bool) (bool, error) {
+	if omitEmpty && v.Len() == 0 {
+		return false, nil
+	}
+	e.writeString("<value><array><data>")
+	for i := 0; i < v.Len(); i++ {
+		if _, err := e.encodeValue(v.Index(i), false); err != nil {
+			return false, prefixPath(err, fmt.Sprintf("[%d]", i))
 		}
-	*/
-	return fmt.Sprintf("<dateTime.iso8601>%04d%02d%02dT%02d:%02d:%02d</dateTime.iso8601>",
-		t.Year(), t.Month(), t.Day(),
-		t.Hour(), t.Minute(), t.Second())
+	}
+	e.writeString("</data></array></value>")
+	return true, nil
+}
+
+// encodeTime encodes t in the DateTimeFormat of the options.
+func (e *Encoder) encodeTime(t time.Time) {
+	layout := "20060102T15:04:05"
+	switch e.opts.dateTime {
+	case DateTimeUTC:
+		t = t.UTC()
+		layout += "Z"
+	case DateTimeOffset:
+		layout += "-07:00"
+	}
+	e.writeElement("dateTime.iso8601", t.AppendFormat(e.scratch[:0], layout))
+}
+
+func (e *Encoder) encodeBase64(data []byte) {
+	e.writeString("<value><base64>")
+	encoder := base64.NewEncoder(base64.StdEncoding, e.buf)
+	encoder.Write(data)
+	encoder.Close()
+	e.buf.WriteString("</base64></value>")
+}
+
+// ----------------------------------------------------------------------------
+// String conversions
+// ----------------------------------------------------------------------------
+
+func rpcRequest2XML(method string, rpc interface{}, opts ...Option) (string, error) {
+	var b bytes.Buffer
+	err := NewEncoder(&b, opts...).EncodeRequest(method, rpc)
+	return b.String(), err
+}
+
+func rpcResponse2XML(rpc interface{}, opts ...Option) (string, error) {
+	var b bytes.Buffer
+	err := NewEncoder(&b, opts...).EncodeResponse(rpc)
+	return b.String(), err
 }
 
-func base642XML(data []byte) string {
-	str := base64.StdEncoding.EncodeToString(data)
-	return fmt.Sprintf("<base64>%s</base64>", str)
+// rpc2XML encodes a single value, returning its <value> element or an empty
+// string when the value is omitted.
+func rpc2XML(value interface{}, omitEmpty bool, opts *options) (string, error) {
+	var b bytes.Buffer
+	e := &Encoder{w: &b, opts: opts}
+	err := e.encode(func() error {
+		_, err := e.encodeValue(reflect.ValueOf(value), omitEmpty)
+		return err
+	})
+	return b.String(), err
 }
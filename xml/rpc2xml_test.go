@@ -5,6 +5,12 @@
 package xml
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
 	"testing"
 	"time"
 )
@@ -142,7 +148,7 @@ func TestRPC2XMLTags(t *testing.T) {
 					"<member><name>Foo</name><value><string>FOO</string></value></member>" +
 					"<member><name>renameBar</name><value><int>123</int></value></member>" +
 					"<member><name>Str</name><value><string>STRING</string></value></member>" +
-					"<member><name>doublename</name><value><double>1.000000</double></value></member>" +
+					"<member><name>doublename</name><value><double>1</double></value></member>" +
 					"<member><name>strname</name><value><string>RENAMED</string></value></member>" +
 					"<member><name>boolname</name><value><boolean>1</boolean></value></member>" +
 					"<member><name>arrayname</name><value><array><data></data></array></value></member>" +
@@ -164,3 +170,380 @@ func TestRPC2XMLTags(t *testing.T) {
 		}
 	}
 }
+
+type Status int
+
+type StructIntegersRpc2Xml struct {
+	Int8   int8
+	Int16  int16
+	Int32  int32
+	Int64  int64
+	Uint   uint
+	Uint8  uint8
+	Uint16 uint16
+	Uint32 uint32
+	Uint64 uint64
+	Status Status
+}
+
+func TestRPC2XMLIntegers(t *testing.T) {
+	req := &StructIntegersRpc2Xml{-8, -16, -32, math.MinInt32, 1, 8, 16, 32, math.MaxInt32, Status(7)}
+	xml, err := rpcResponse2XML(req)
+	if err != nil {
+		t.Error("RPC2XML conversion failed", err)
+	}
+	expected := "<methodResponse><params>" +
+		"<param><value><int>-8</int></value></param>" +
+		"<param><value><int>-16</int></value></param>" +
+		"<param><value><int>-32</int></value></param>" +
+		"<param><value><int>-2147483648</int></value></param>" +
+		"<param><value><int>1</int></value></param>" +
+		"<param><value><int>8</int></value></param>" +
+		"<param><value><int>16</int></value></param>" +
+		"<param><value><int>32</int></value></param>" +
+		"<param><value><int>2147483647</int></value></param>" +
+		"<param><value><int>7</int></value></param>" +
+		"</params></methodResponse>"
+	if xml != expected {
+		t.Error("RPC2XML integers conversion failed")
+		t.Error("Expected", expected)
+		t.Error("Got", xml)
+	}
+}
+
+func TestRPC2XMLIntegerOverflow(t *testing.T) {
+	tests := []interface{}{
+		&struct{ Int64 int64 }{math.MaxInt32 + 1},
+		&struct{ Int64 int64 }{math.MinInt32 - 1},
+		&struct{ Uint32 uint32 }{math.MaxUint32},
+		&struct{ Sub struct{ Data []int64 } }{struct{ Data []int64 }{[]int64{1, 1 << 40}}},
+	}
+	for i, req := range tests {
+		if xml, err := rpcResponse2XML(req); err == nil {
+			t.Errorf("test %d: expected overflow error, but got: %s", i, xml)
+		}
+	}
+
+	_, err := rpcResponse2XML(&struct{ Uint64 uint64 }{math.MaxUint64}, WithExtensions())
+	if err == nil || !strings.Contains(err.Error(), "overflows 64-bit XML-RPC ex:i8") {
+		t.Error("expected ex:i8 overflow error, but got:", err)
+	}
+}
+
+type StructExtensionsRpc2Xml struct {
+	Int8    int8
+	Int16   int16
+	Int64   int64
+	Int     int
+	Float32 float32
+	BigInt  *big.Int
+	BigDec  *big.Float
+}
+
+func TestRPC2XMLExtensions(t *testing.T) {
+	bigInt, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	req := &StructExtensionsRpc2Xml{-8, 16, 64, 1 << 40, 1.5, bigInt, big.NewFloat(0.25)}
+	xml, err := rpcResponse2XML(req, WithExtensions())
+	if err != nil {
+		t.Error("RPC2XML conversion failed", err)
+	}
+	expected := `<methodResponse xmlns:ex="http://ws.apache.org/xmlrpc/namespaces/extensions"><params>` +
+		"<param><value><ex:i1>-8</ex:i1></value></param>" +
+		"<param><value><ex:i2>16</ex:i2></value></param>" +
+		"<param><value><ex:i8>64</ex:i8></value></param>" +
+		"<param><value><ex:i8>1099511627776</ex:i8></value></param>" +
+		"<param><value><ex:float>1.5</ex:float></value></param>" +
+		"<param><value><ex:biginteger>123456789012345678901234567890</ex:biginteger></value></param>" +
+		"<param><value><ex:bigdecimal>0.25</ex:bigdecimal></value></param>" +
+		"</params></methodResponse>"
+	if xml != expected {
+		t.Error("RPC2XML extensions conversion failed")
+		t.Error("Expected", expected)
+		t.Error("Got", xml)
+	}
+
+	if xml, err := rpcResponse2XML(req); err == nil {
+		t.Error("expected error for big types without extensions, but got:", xml)
+	}
+}
+
+type textKey struct {
+	A, B string
+}
+
+func (k textKey) MarshalText() ([]byte, error) {
+	return []byte(k.A + "/" + k.B), nil
+}
+
+type StructMapRpc2Xml struct {
+	Dynamic map[string]interface{}
+	Labels  map[string]string
+	Keys    map[textKey]int
+}
+
+func TestRPC2XMLMap(t *testing.T) {
+	req := &StructMapRpc2Xml{
+		Dynamic: map[string]interface{}{
+			"str":   "foo",
+			"int":   42,
+			"nil":   nil,
+			"list":  []interface{}{1, "two"},
+			"a<b":   true,
+			"inner": map[string]interface{}{"x": 1.5},
+		},
+		Labels: map[string]string{"team": "core", "env": "prod"},
+		Keys:   map[textKey]int{{"b", "c"}: 2, {"a", "b"}: 1},
+	}
+	expected := "<methodResponse><params>" +
+		"<param><value><struct>" +
+		"<member><name>a&lt;b</name><value><boolean>1</boolean></value></member>" +
+		"<member><name>inner</name><value><struct><member><name>x</name><value><double>1.5</double></value></member></struct></value></member>" +
+		"<member><name>int</name><value><int>42</int></value></member>" +
+		"<member><name>list</name><value><array><data><value><int>1</int></value><value><string>two</string></value></data></array></value></member>" +
+		"<member><name>nil</name><value><nil/></value></member>" +
+		"<member><name>str</name><value><string>foo</string></value></member>" +
+		"</struct></value></param>" +
+		"<param><value><struct>" +
+		"<member><name>env</name><value><string>prod</string></value></member>" +
+		"<member><name>team</name><value><string>core</string></value></member>" +
+		"</struct></value></param>" +
+		"<param><value><struct>" +
+		"<member><name>a/b</name><value><int>1</int></value></member>" +
+		"<member><name>b/c</name><value><int>2</int></value></member>" +
+		"</struct></value></param>" +
+		"</params></methodResponse>"
+
+	// Run several times: map iteration order is random.
+	for i := 0; i < 10; i++ {
+		xml, err := rpcResponse2XML(req)
+		if err != nil {
+			t.Fatal("RPC2XML conversion failed", err)
+		}
+		if xml != expected {
+			t.Error("RPC2XML map conversion failed")
+			t.Error("Expected", expected)
+			t.Error("Got", xml)
+			break
+		}
+	}
+
+	if xml, err := rpcResponse2XML(&struct{ M map[int]string }{map[int]string{1: "a"}}); err == nil {
+		t.Error("expected error for unsupported key type, but got:", xml)
+	}
+}
+
+// Money marshals itself as a string with currency.
+type Money struct {
+	Cents    int64
+	Currency string
+}
+
+func (m Money) MarshalXMLRPC() ([]byte, error) {
+	return []byte(fmt.Sprintf("<string>%d.%02d %s</string>", m.Cents/100, m.Cents%100, m.Currency)), nil
+}
+
+// Level marshals itself with a pointer receiver.
+type Level int
+
+func (l *Level) MarshalXMLRPC() ([]byte, error) {
+	return []byte([]string{"<string>low</string>", "<string>high</string>"}[*l]), nil
+}
+
+type BrokenMarshaler struct{}
+
+func (BrokenMarshaler) MarshalXMLRPC() ([]byte, error) {
+	return []byte("<string>unterminated"), nil
+}
+
+type StructMarshalerRpc2Xml struct {
+	Price   Money
+	Level   Level
+	Missing *Money
+}
+
+func TestRPC2XMLMarshaler(t *testing.T) {
+	req := &StructMarshalerRpc2Xml{Money{1250, "EUR"}, Level(1), nil}
+	xml, err := rpcResponse2XML(req)
+	if err != nil {
+		t.Error("RPC2XML conversion failed", err)
+	}
+	expected := "<methodResponse><params>" +
+		"<param><value><string>12.50 EUR</string></value></param>" +
+		"<param><value><string>high</string></value></param>" +
+		"<param><value><nil/></value></param>" +
+		"</params></methodResponse>"
+	if xml != expected {
+		t.Error("RPC2XML Marshaler conversion failed")
+		t.Error("Expected", expected)
+		t.Error("Got", xml)
+	}
+
+	if xml, err := rpcResponse2XML(&struct{ B BrokenMarshaler }{}); err == nil {
+		t.Error("expected error for malformed Marshaler output, but got:", xml)
+	}
+}
+
+type StructEncoderRpc2Xml struct {
+	Items []SubStructRpc2Xml
+	Empty struct {
+		Inner struct {
+			Str string `xml:",omitempty"`
+		}
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	req := &StructEncoderRpc2Xml{Items: make([]SubStructRpc2Xml, 1000)}
+	for i := range req.Items {
+		req.Items[i] = SubStructRpc2Xml{i, "item", []int{i}}
+	}
+
+	var b bytes.Buffer
+	if err := NewEncoder(&b).EncodeResponse(req); err != nil {
+		t.Fatal("Encoder failed", err)
+	}
+	xml := b.String()
+	if !strings.HasPrefix(xml, "<methodResponse><params><param><value><array><data><value><struct><member><name>Foo</name><value><int>0</int></value></member>") {
+		t.Error("Wrong prefix", xml[:200])
+	}
+	if !strings.HasSuffix(xml, "</data></array></value></param><param></param></params></methodResponse>") {
+		t.Error("Wrong suffix", xml[len(xml)-200:])
+	}
+	if n := strings.Count(xml, "<struct>"); n != len(req.Items) {
+		t.Errorf("Expected %d structs, got %d", len(req.Items), n)
+	}
+
+	b.Reset()
+	if err := NewEncoder(&b).EncodeResponse(&struct{ N uint64 }{math.MaxUint64}); err == nil {
+		t.Error("Expected error for overflowing integer")
+	}
+	if b.Len() != 0 {
+		t.Error("Expected no output on error, got", b.String())
+	}
+}
+
+type StructEncodeErrorRpc2Xml struct {
+	Items  []map[string]interface{}
+	hidden chan int
+}
+
+func TestRPC2XMLEncodeError(t *testing.T) {
+	tests := []struct {
+		Rpc  interface{}
+		Path string
+	}{
+		{&StructEncodeErrorRpc2Xml{Items: []map[string]interface{}{{}, {"price": int64(math.MaxInt64)}}}, `Args.Items[1]["price"]`},
+		{&struct{ Sub SubStructRpc2Xml }{SubStructRpc2Xml{Data: []int{1, math.MinInt32 - 1}}}, "Args.Sub.Data[1]"},
+		{&struct{ C chan int }{}, "Args.C"},
+		{&struct{ C complex128 }{}, "Args.C"},
+		{&struct{ F func() }{}, "Args.F"},
+		{&struct{ B BrokenMarshaler }{}, "Args.B"},
+	}
+	for i, test := range tests {
+		_, err := EncodeClientRequest("Some.Method", test.Rpc)
+		var encodeErr *EncodeError
+		if !errors.As(err, &encodeErr) || encodeErr.Path != test.Path {
+			t.Errorf("test %d: expected EncodeError at %s, but got: %v", i, test.Path, err)
+		}
+	}
+
+	xml, err := rpcRequest2XML("Some.Method", &struct{ Sub StructEncodeErrorRpc2Xml }{StructEncodeErrorRpc2Xml{Items: []map[string]interface{}{}}})
+	if err != nil {
+		t.Error("expected unexported fields to be skipped, but got:", err)
+	}
+	expected := "<methodCall><methodName>Some.Method</methodName><params><param><value><struct><member><name>Items</name><value><array><data></data></array></value></member></struct></value></param></params></methodCall>"
+	if xml != expected {
+		t.Error("Expected", expected)
+		t.Error("Got", xml)
+	}
+}
+
+func TestRPC2XMLDateTime(t *testing.T) {
+	tz := time.FixedZone("CET", 3600)
+	value := time.Date(2012, time.July, 17, 14, 8, 55, 0, tz)
+	tests := []struct {
+		Format   DateTimeFormat
+		Expected string
+	}{
+		{DateTimeZoneless, "20120717T14:08:55"},
+		{DateTimeUTC, "20120717T13:08:55Z"},
+		{DateTimeOffset, "20120717T14:08:55+01:00"},
+	}
+	for _, test := range tests {
+		xml, err := rpc2XML(value, false, newOptions([]Option{WithDateTimeFormat(test.Format)}))
+		if err != nil {
+			t.Fatal("expected encoding to succeed, but got:", err)
+		}
+		expected := "<value><dateTime.iso8601>" + test.Expected + "</dateTime.iso8601></value>"
+		if xml != expected {
+			t.Error("Expected", expected)
+			t.Error("Got", xml)
+		}
+	}
+}
+
+func TestRPC2XMLFloats(t *testing.T) {
+	tests := []struct {
+		Value    interface{}
+		Expected string
+	}{
+		{1e-9, "<value><double>0.000000001</double></value>"},
+		{123456789.125, "<value><double>123456789.125</double></value>"},
+		{-2.5, "<value><double>-2.5</double></value>"},
+		{float32(0.1), "<value><double>0.1</double></value>"},
+	}
+	for _, test := range tests {
+		xml, err := rpc2XML(test.Value, false, &options{})
+		if err != nil {
+			t.Fatal("expected encoding to succeed, but got:", err)
+		}
+		if xml != test.Expected {
+			t.Error("Expected", test.Expected)
+			t.Error("Got", xml)
+		}
+	}
+
+	for _, value := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		_, err := rpcRequest2XML("Some.Method", &struct{ Value float64 }{value})
+		if e, ok := err.(*EncodeError); !ok || e.Path != "Args.Value" {
+			t.Errorf("expected EncodeError for %v, but got: %v", value, err)
+		}
+	}
+}
+
+type StructEscapingRpc2Xml struct {
+	Value string `xml:"a&b"`
+}
+
+func TestRPC2XMLEscaping(t *testing.T) {
+	xml, err := rpcRequest2XML("Some<Method>", &struct{ Item StructEscapingRpc2Xml }{StructEscapingRpc2Xml{"x\r\n\"y\" \x00\x1b\xff\U0001F600"}})
+	if err != nil {
+		t.Fatal("expected encoding to succeed, but got:", err)
+	}
+	expected := "<methodCall><methodName>Some&lt;Method&gt;</methodName><params><param><value><struct>" +
+		"<member><name>a&amp;b</name><value><string>x&#xD;\n&quot;y&quot; \uFFFD\uFFFD\uFFFD\U0001F600</string></value></member>" +
+		"</struct></value></param></params></methodCall>"
+	if xml != expected {
+		t.Error("Expected", expected)
+		t.Error("Got", xml)
+	}
+
+	var req struct{ Item struct{ Value string } }
+	if err := xml2RPC(strings.Replace(xml, "a&amp;b", "value", 1), &req); err != nil || req.Item.Value != "x\r\n\"y\" \uFFFD\uFFFD\uFFFD\U0001F600" {
+		t.Errorf("expected escaped string to decode, but got %q, err: %v", req.Item.Value, err)
+	}
+
+	value, err := rpc2XML("a\x00b\x1bc", false, newOptions([]Option{WithInvalidChars(StripInvalidChars)}))
+	if err != nil || value != "<value><string>abc</string></value>" {
+		t.Error("expected invalid characters to be stripped, but got:", value, err)
+	}
+	_, err = rpcRequest2XML("Some.Method", &struct{ Value []string }{[]string{"ok", "a\x00b"}},
+		WithInvalidChars(RejectInvalidChars))
+	if e, ok := err.(*EncodeError); !ok || e.Path != "Args.Value[1]" {
+		t.Error("expected EncodeError for invalid character, but got:", err)
+	}
+	_, err = rpcRequest2XML("Some\x00Method", &struct{}{}, WithInvalidChars(RejectInvalidChars))
+	if e, ok := err.(*EncodeError); !ok || e.Path != "MethodName" {
+		t.Error("expected EncodeError for invalid method name, but got:", err)
+	}
+}
@@ -5,10 +5,13 @@
 package xml
 
 import (
+	"bytes"
 	"encoding/xml"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
+	"sync"
 
 	"github.com/maddogwg/rpc/v2"
 )
@@ -18,39 +21,54 @@ import (
 // ----------------------------------------------------------------------------
 
 // NewCodec returns a new XML-RPC Codec.
-func NewCodec() *Codec {
+func NewCodec(opts ...Option) *Codec {
 	return &Codec{
 		aliases: make(map[string]string),
+		options: opts,
 	}
 }
 
 // Codec creates a CodecRequest to process each request.
 type Codec struct {
+	mutex   sync.RWMutex
 	aliases map[string]string
+	options []Option
 }
 
-// RegisterAlias creates a method alias
+// RegisterAlias creates a method alias. It is safe to call while serving.
 func (c *Codec) RegisterAlias(alias, method string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.aliases[alias] = method
 }
 
-// NewRequest returns a CodecRequest.
-func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
-	rawxml, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return &CodecRequest{err: err}
+// resolve returns the method aliased by method, or method itself.
+func (c *Codec) resolve(method string) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if aliased, ok := c.aliases[method]; ok {
+		return aliased
 	}
-	defer r.Body.Close()
+	return method
+}
 
-	var request ServerRequest
-	if err := xml.Unmarshal(rawxml, &request); err != nil {
-		return &CodecRequest{err: err}
+// NewRequest returns a CodecRequest.
+//
+// Only the method name is read at this point; the params are decoded from
+// the request body by ReadRequest.
+func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
+	request := &ServerRequest{}
+	var body io.Reader = r.Body
+	if newOptions(c.options).captureRequest {
+		body = io.TeeReader(r.Body, &request.rawxml)
 	}
-	request.rawxml = string(rawxml)
-	if method, ok := c.aliases[request.Method]; ok {
-		request.Method = method
+	decoder := NewDecoder(body, c.options...)
+	method, err := decoder.DecodeMethodName()
+	if err != nil {
+		return &CodecRequest{request: request, err: err, options: c.options}
 	}
-	return &CodecRequest{request: &request, response: &ServerResponse{}}
+	request.Method = c.resolve(method)
+	return &CodecRequest{request: request, response: &ServerResponse{}, decoder: decoder, options: c.options}
 }
 
 // ----------------------------------------------------------------------------
@@ -60,16 +78,18 @@ func (c *Codec) NewRequest(r *http.Request) rpc.CodecRequest {
 type ServerRequest struct {
 	Name   xml.Name `xml:"methodCall"`
 	Method string   `xml:"methodName"`
-	rawxml string
+	rawxml bytes.Buffer
 }
 type ServerResponse struct {
-	rawxml string
+	rawxml bytes.Buffer
 }
 
 // CodecRequest decodes and encodes a single request.
 type CodecRequest struct {
 	request  *ServerRequest
 	response *ServerResponse
+	decoder  *Decoder
+	options  []Option
 	err      error
 }
 
@@ -88,40 +108,101 @@ func (c *CodecRequest) Method() (string, error) {
 // args is the pointer to the Service.Args structure
 // it gets populated from temporary XML structure
 func (c *CodecRequest) ReadRequest(args interface{}) error {
-	return xml2RPC(c.request.rawxml, args)
+	return c.decoder.Decode(args)
 }
 
+// RequestXML returns the raw request.
+//
+// Requests are decoded as they are read, so they are only kept, as they are
+// read, with the WithRequestCapture option. Otherwise RequestXML returns an
+// empty string. Once ReadRequest returns, the raw request holds at least
+// the params.
 func (c *CodecRequest) RequestXML() string {
-	return c.request.rawxml
+	if c.request == nil {
+		return ""
+	}
+	return c.request.rawxml.String()
 }
 
 // WriteResponse encodes the response and writes it to the ResponseWriter.
 //
 // response is the pointer to the Service.Response structure
 // it gets encoded into the XML-RPC xml string
+//
+// A response that can't be encoded is replaced with FaultInternalError. The
+// response is streamed, so if the error occurs after part of it was sent,
+// the connection is aborted instead of leaving a truncated body.
 func (c *CodecRequest) WriteResponse(w http.ResponseWriter, response interface{}) {
-	c.response.rawxml, _ = rpcResponse2XML(response)
 	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
-	w.Write([]byte(c.response.rawxml))
+	var out io.Writer = w
+	if c.response != nil && newOptions(c.options).captureResponse {
+		c.response.rawxml.Reset()
+		out = io.MultiWriter(w, &c.response.rawxml)
+	}
+	encoder := NewEncoder(out, c.options...)
+	if err := encoder.EncodeResponse(response); err != nil {
+		if encoder.written {
+			panic(http.ErrAbortHandler)
+		}
+		fault := FaultInternalError
+		fault.String += ": " + err.Error()
+		c.WriteError(w, http.StatusInternalServerError, fault)
+	}
 }
 
+// ResponseXML returns the encoded response.
+//
+// Responses are streamed to the ResponseWriter, so they are only kept, as
+// they are written, with the WithResponseCapture option. Otherwise
+// ResponseXML returns an empty string.
 func (c *CodecRequest) ResponseXML() string {
-	return c.response.rawxml
+	if c.response == nil {
+		return ""
+	}
+	return c.response.rawxml.String()
 }
 
 // Writes an error produced by the server.
+//
+// The fault is sent with 200 OK, as the spec requires, regardless of status,
+// unless the WithFaultStatus option sets another status for its code.
 func (c *CodecRequest) WriteError(w http.ResponseWriter, status int, err error) {
-	var xmlstr string
 	var fault Fault
 
+	o := newOptions(c.options)
 	switch err.(type) {
 	case Fault:
 		fault = err.(Fault)
+	case *DecodeError:
+		fault = err.(*DecodeError).AsFault()
 	default:
-		fault = FaultApplicationError
-		fault.String += fmt.Sprintf(": %v", err)
+		var ok bool
+		if o.faults != nil {
+			fault, ok = o.faults.Fault(err)
+		}
+		if !ok && !errors.As(err, &fault) {
+			fault = FaultApplicationError
+			fault.String += fmt.Sprintf(": %v", err)
+		}
+	}
+	if o.faultRewrite != nil {
+		fault = o.faultRewrite(fault, err)
 	}
-	xmlstr = fault2XML(fault)
 	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
-	w.Write([]byte(xmlstr))
+	if o.faultHeaders != nil {
+		o.faultHeaders(w.Header(), fault)
+	}
+	if status, ok := o.faultStatus[fault.Code]; ok {
+		w.WriteHeader(status)
+	}
+	encoder := NewEncoder(w, c.options...)
+	if err := encoder.EncodeFault(fault); err != nil {
+		if encoder.written {
+			panic(http.ErrAbortHandler)
+		}
+		// The fault string can't be encoded, e.g. with RejectInvalidChars.
+		fault = FaultInternalError
+		fault.String += ": " + err.Error()
+		NewEncoder(w).EncodeFault(fault)
+	}
 }
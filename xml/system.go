@@ -0,0 +1,283 @@
+// Copyright 2013 Ivan Danyliuk
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/maddogwg/rpc/v2"
+)
+
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfRequest = reflect.TypeOf((*http.Request)(nil))
+	typeOfTime    = reflect.TypeOf(time.Time{})
+	typeOfBytes   = reflect.TypeOf([]byte(nil))
+)
+
+// ----------------------------------------------------------------------------
+// SystemService
+// ----------------------------------------------------------------------------
+
+// SystemService implements the standard XML-RPC introspection methods:
+// system.listMethods, system.methodSignature and system.methodHelp, as well
+// as system.multicall.
+//
+// Only services registered through SystemService.RegisterService are
+// visible to introspection, since rpc.Server doesn't expose its registry.
+type SystemService struct {
+	codec   *Codec
+	server  *rpc.Server
+	mutex   sync.Mutex
+	methods map[string]*methodInfo
+}
+
+type methodInfo struct {
+	signature []string
+	help      string
+}
+
+type serviceInfo struct {
+	name    string
+	methods map[string]*methodInfo
+	// err is set by options that don't apply to the service.
+	err error
+}
+
+// ServiceOption configures a service registered with SystemService.
+type ServiceOption func(*serviceInfo)
+
+// WithHelp sets the text returned by system.methodHelp for the method of the
+// registered service. method is the bare method name, e.g. "Say";
+// RegisterService fails if the service has no such method.
+func WithHelp(method, help string) ServiceOption {
+	return func(service *serviceInfo) {
+		info, ok := service.methods[service.name+"."+method]
+		if !ok {
+			service.err = fmt.Errorf("xml: WithHelp: service %q has no method %q", service.name, method)
+			return
+		}
+		info.help = help
+	}
+}
+
+// RegisterSystemService registers the "system" service on s and aliases the
+// standard introspection and multicall method names to it. It is opt-in: servers that don't
+// call it don't expose any system.* methods.
+func (c *Codec) RegisterSystemService(s *rpc.Server) (*SystemService, error) {
+	sys := &SystemService{
+		codec:  c,
+		server: s,
+		methods: map[string]*methodInfo{
+			"system.listMethods": {
+				signature: []string{"array"},
+				help:      "Returns the list of methods implemented by the server.",
+			},
+			"system.methodSignature": {
+				signature: []string{"array", "string"},
+				help:      "Returns the list of signatures of the given method.",
+			},
+			"system.methodHelp": {
+				signature: []string{"string", "string"},
+				help:      "Returns the help text of the given method.",
+			},
+			"system.multicall": {
+				signature: []string{"array", "array"},
+				help:      "Processes an array of calls and returns an array of results.",
+			},
+		},
+	}
+	if err := s.RegisterService(sys, "system"); err != nil {
+		return nil, err
+	}
+	c.RegisterAlias("system.listMethods", "system.ListMethods")
+	c.RegisterAlias("system.methodSignature", "system.MethodSignature")
+	c.RegisterAlias("system.methodHelp", "system.MethodHelp")
+	c.RegisterAlias("system.multicall", "system.Multicall")
+	return sys, nil
+}
+
+// RegisterService registers receiver on the underlying rpc.Server, as
+// rpc.Server.RegisterService does, and records its methods for introspection.
+// Nothing is registered if an option doesn't apply to the service.
+func (s *SystemService) RegisterService(receiver interface{}, name string, opts ...ServiceOption) error {
+	serviceName := name
+	if serviceName == "" {
+		serviceName = reflect.Indirect(reflect.ValueOf(receiver)).Type().Name()
+	}
+
+	service := &serviceInfo{name: serviceName, methods: make(map[string]*methodInfo)}
+	rcvrType := reflect.TypeOf(receiver)
+	for i := 0; i < rcvrType.NumMethod(); i++ {
+		method := rcvrType.Method(i)
+		if args, reply, ok := serviceMethodTypes(method); ok {
+			service.methods[serviceName+"."+method.Name] = &methodInfo{
+				signature: methodSignature(args, reply),
+			}
+		}
+	}
+	for _, opt := range opts {
+		opt(service)
+	}
+	if service.err != nil {
+		return service.err
+	}
+	if err := s.server.RegisterService(receiver, name); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for method, info := range service.methods {
+		s.methods[method] = info
+	}
+	return nil
+}
+
+// serviceMethodTypes returns args and reply types of a method satisfying the
+// gorilla/rpc rules: func(*http.Request, *args, *reply) error.
+func serviceMethodTypes(method reflect.Method) (args, reply reflect.Type, ok bool) {
+	mtype := method.Type
+	if method.PkgPath != "" || mtype.NumIn() != 4 || mtype.NumOut() != 1 {
+		return nil, nil, false
+	}
+	if mtype.In(1) != typeOfRequest || mtype.Out(0) != typeOfError {
+		return nil, nil, false
+	}
+	args, reply = mtype.In(2), mtype.In(3)
+	if args.Kind() != reflect.Ptr || reply.Kind() != reflect.Ptr {
+		return nil, nil, false
+	}
+	return args.Elem(), reply.Elem(), true
+}
+
+// methodSignature derives a XML-RPC signature from args and reply structure
+// types: the return type followed by the parameter types. A reply with
+// several fields reports the type of the first one.
+func methodSignature(args, reply reflect.Type) []string {
+	signature := []string{"nil"}
+	if reply.Kind() == reflect.Struct && reply.NumField() > 0 {
+		signature[0] = xmlrpcType(reply.Field(0).Type)
+	}
+	if args.Kind() == reflect.Struct {
+		for i := 0; i < args.NumField(); i++ {
+			signature = append(signature, xmlrpcType(args.Field(i).Type))
+		}
+	}
+	return signature
+}
+
+// xmlrpcType returns the name of the XML-RPC type a Go type is encoded as.
+func xmlrpcType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case typeOfTime:
+		return "dateTime.iso8601"
+	case typeOfBytes:
+		return "base64"
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "double"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Struct, reflect.Map:
+		return "struct"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "undef"
+}
+
+// lookup returns the introspection info of method, resolving codec aliases.
+func (s *SystemService) lookup(method string) (*methodInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	info, ok := s.methods[method]
+	if !ok {
+		info, ok = s.methods[s.codec.resolve(method)]
+	}
+	if !ok {
+		fault := FaultInvalidParams
+		fault.String += fmt.Sprintf(": unknown method %q", method)
+		return nil, fault
+	}
+	return info, nil
+}
+
+// ListMethodsReply is the reply of system.listMethods.
+type ListMethodsReply struct {
+	Methods []string
+}
+
+// MethodNameArgs are the arguments of system.methodSignature and
+// system.methodHelp.
+type MethodNameArgs struct {
+	MethodName string
+}
+
+// MethodSignatureReply is the reply of system.methodSignature.
+type MethodSignatureReply struct {
+	Signatures [][]string
+}
+
+// MethodHelpReply is the reply of system.methodHelp.
+type MethodHelpReply struct {
+	Help string
+}
+
+// ListMethods implements system.listMethods.
+//
+// It lists all introspectable methods, along with the codec aliases pointing
+// to them, in lexical order.
+func (s *SystemService) ListMethods(r *http.Request, args *struct{}, reply *ListMethodsReply) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	reply.Methods = make([]string, 0, len(s.methods))
+	for method := range s.methods {
+		reply.Methods = append(reply.Methods, method)
+	}
+	s.codec.mutex.RLock()
+	for alias, method := range s.codec.aliases {
+		if _, ok := s.methods[method]; ok {
+			reply.Methods = append(reply.Methods, alias)
+		}
+	}
+	s.codec.mutex.RUnlock()
+	sort.Strings(reply.Methods)
+	return nil
+}
+
+// MethodSignature implements system.methodSignature.
+func (s *SystemService) MethodSignature(r *http.Request, args *MethodNameArgs, reply *MethodSignatureReply) error {
+	info, err := s.lookup(args.MethodName)
+	if err != nil {
+		return err
+	}
+	reply.Signatures = [][]string{info.signature}
+	return nil
+}
+
+// MethodHelp implements system.methodHelp.
+func (s *SystemService) MethodHelp(r *http.Request, args *MethodNameArgs, reply *MethodHelpReply) error {
+	info, err := s.lookup(args.MethodName)
+	if err != nil {
+		return err
+	}
+	reply.Help = info.help
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2013 Ivan Danyliuk
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xml
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/maddogwg/rpc/v2"
+)
+
+// executeRaw posts a raw XML-RPC request, as third-party clients send it.
+func executeRaw(s *rpc.Server, body string, res interface{}) error {
+	r, _ := http.NewRequest("POST", "http://localhost:8080/", bytes.NewBufferString(body))
+	r.Header.Set("Content-Type", "text/xml")
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	return DecodeClientResponse(w.Body, res)
+}
+
+func newSystemServer(t *testing.T) (*rpc.Server, *SystemService) {
+	s := rpc.NewServer()
+	codec := NewCodec()
+	s.RegisterCodec(codec, "text/xml")
+	sys, err := codec.RegisterSystemService(s)
+	if err != nil {
+		t.Fatal("RegisterSystemService failed:", err)
+	}
+	if err := sys.RegisterService(new(Service1), "", WithHelp("Multiply", "Multiplies A by B.")); err != nil {
+		t.Fatal("RegisterService failed:", err)
+	}
+	if err := sys.RegisterService(new(Service3), ""); err != nil {
+		t.Fatal("RegisterService failed:", err)
+	}
+	codec.RegisterAlias("multiply", "Service1.Multiply")
+	return s, sys
+}
+
+func TestSystemListMethods(t *testing.T) {
+	s, _ := newSystemServer(t)
+
+	var res ListMethodsReply
+	err := executeRaw(s, "<?xml version='1.0'?><methodCall><methodName>system.listMethods</methodName><params></params></methodCall>", &res)
+	if err != nil {
+		t.Fatal("Expected err to be nil, but got:", err)
+	}
+	expected := []string{
+		"Service1.Multiply",
+		"Service3.GetInfo",
+		"multiply",
+		"system.listMethods",
+		"system.methodHelp",
+		"system.methodSignature",
+		"system.multicall",
+	}
+	if !reflect.DeepEqual(res.Methods, expected) {
+		t.Errorf("Wrong response: %v.", res.Methods)
+	}
+}
+
+func TestSystemMethodSignature(t *testing.T) {
+	s, _ := newSystemServer(t)
+
+	tests := map[string][][]string{
+		"Service1.Multiply":  {{"int", "int", "int"}},
+		"multiply":           {{"int", "int", "int"}},
+		"Service3.GetInfo":   {{"struct", "struct"}},
+		"system.methodHelp":  {{"string", "string"}},
+		"system.listMethods": {{"array"}},
+	}
+	for method, expected := range tests {
+		var res MethodSignatureReply
+		err := executeRaw(s, "<methodCall><methodName>system.methodSignature</methodName><params><param><value><string>"+method+"</string></value></param></params></methodCall>", &res)
+		if err != nil {
+			t.Errorf("%s: expected err to be nil, but got: %v", method, err)
+			continue
+		}
+		if !reflect.DeepEqual(res.Signatures, expected) {
+			t.Errorf("%s: wrong signatures: %v", method, res.Signatures)
+		}
+	}
+}
+
+func TestSystemMethodHelp(t *testing.T) {
+	s, _ := newSystemServer(t)
+
+	var res MethodHelpReply
+	err := executeRaw(s, "<methodCall><methodName>system.methodHelp</methodName><params><param><value>Service1.Multiply</value></param></params></methodCall>", &res)
+	if err != nil {
+		t.Fatal("Expected err to be nil, but got:", err)
+	}
+	if res.Help != "Multiplies A by B." {
+		t.Errorf("Wrong response: %v.", res.Help)
+	}
+
+	err = executeRaw(s, "<methodCall><methodName>system.methodHelp</methodName><params><param><value>Nope.Nope</value></param></params></methodCall>", &res)
+	fault, ok := err.(Fault)
+	if !ok || fault.Code != FaultInvalidParams.Code {
+		t.Errorf("expected FaultInvalidParams, but got: %v", err)
+	}
+}
+
+func TestSystemWithHelpUnknownMethod(t *testing.T) {
+	s, sys := newSystemServer(t)
+	err := sys.RegisterService(new(Service2), "", WithHelp("Nope", "Does nothing."))
+	if err == nil || !strings.Contains(err.Error(), `no method "Nope"`) {
+		t.Error("expected unknown method error, but got:", err)
+	}
+	if s.HasMethod("Service2.GetGreeting") {
+		t.Error("expected Service2 not to be registered")
+	}
+}
+
+func TestSystemAliasesWhileServing(t *testing.T) {
+	s, sys := newSystemServer(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			sys.codec.RegisterAlias("times"+strconv.Itoa(i), "Service1.Multiply")
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		var res ListMethodsReply
+		if err := executeRaw(s, "<methodCall><methodName>system.listMethods</methodName><params></params></methodCall>", &res); err != nil {
+			t.Fatal("Expected err to be nil, but got:", err)
+		}
+	}
+	<-done
+}
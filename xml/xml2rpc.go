@@ -9,8 +9,11 @@ import (
 	"encoding/base64"
 	"encoding/xml"
 	"fmt"
+	"io"
+	"math/big"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 	"unicode"
 	"unicode/utf8"
@@ -19,186 +22,1179 @@ import (
 	_ "github.com/rogpeppe/go-charset/data"
 )
 
-// Types used for unmarshalling
-type response struct {
-	Name   xml.Name   `xml:"methodResponse"`
-	Params []param    `xml:"params>param"`
-	Fault  faultValue `xml:"fault,omitempty"`
+// ----------------------------------------------------------------------------
+// Decoder
+// ----------------------------------------------------------------------------
+
+// DecodeError is returned when a value can't be decoded, such as a string
+// given for an integer field or malformed XML.
+//
+// It wraps the Fault describing the failure, which errors.As extracts, and
+// which servers send to clients along with the path and position.
+type DecodeError struct {
+	Fault Fault
+	// Path locates the value, e.g. "Args.Items[3].Price". The root is
+	// "Args" for requests and "Reply" for responses.
+	Path string
+	// Found is the XML-RPC type of the value, e.g. "int", and Expected the
+	// Go type it was decoded into. Both are unset for malformed XML.
+	Found    string
+	Expected reflect.Type
+	// Offset, Line and Column give the position of the input where
+	// decoding failed.
+	Offset       int64
+	Line, Column int
+	// Err is the underlying error, if any, such as the error returned by
+	// an Unmarshaler.
+	Err error
 }
 
-type param struct {
-	Value value `xml:"value"`
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Fault.Code, e.message())
 }
 
-type value struct {
-	Array    []value  `xml:"array>data>value"`
-	Struct   []member `xml:"struct>member"`
-	String   string   `xml:"string"`
-	Int      string   `xml:"int"`
-	Int4     string   `xml:"i4"`
-	Double   string   `xml:"double"`
-	Boolean  string   `xml:"boolean"`
-	DateTime string   `xml:"dateTime.iso8601"`
-	Base64   string   `xml:"base64"`
-	Raw      string   `xml:",innerxml"` // the value can be defualt string
+// Unwrap returns the Fault.
+func (e *DecodeError) Unwrap() error {
+	return e.Fault
 }
 
-type member struct {
-	Name  string `xml:"name"`
-	Value value  `xml:"value"`
+// AsFault returns the Fault, detailed with the path and position of the
+// error.
+func (e *DecodeError) AsFault() Fault {
+	return Fault{Code: e.Fault.Code, String: e.message()}
 }
 
-func xml2RPC(xmlraw string, rpc interface{}) error {
-	// Unmarshal raw XML into the temporal structure
-	var ret response
-	decoder := xml.NewDecoder(bytes.NewReader([]byte(xmlraw)))
-	decoder.CharsetReader = charset.NewReader
-	err := decoder.Decode(&ret)
-	if err != nil {
-		return FaultDecode
+func (e *DecodeError) message() string {
+	msg := e.Fault.String
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Path != "" {
+		msg += " at " + e.Path
+	}
+	if e.Line > 0 {
+		msg += fmt.Sprintf(" (line %d, column %d)", e.Line, e.Column)
+	}
+	return msg
+}
+
+// Decoder reads XML-RPC messages from an input stream.
+//
+// Messages are decoded token by token, straight into the target structure,
+// without reading the whole body in memory first.
+type Decoder struct {
+	d    *xml.Decoder
+	opts *options
+
+	// root is the root of the paths of DecodeErrors, see DecodeError.
+	root    string
+	depth   int
+	started bool
+	done    bool
+	// next is a child element of the root read ahead by DecodeMethodName.
+	next *xml.StartElement
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader, opts ...Option) *Decoder {
+	o := newOptions(opts)
+	if o.limits.MaxBytes > 0 {
+		r = &limitReader{r: r, n: o.limits.MaxBytes, max: o.limits.MaxBytes}
 	}
+	d := xml.NewDecoder(r)
+	d.CharsetReader = charset.NewReader
+	return &Decoder{d: d, opts: o}
+}
+
+// limitReader fails with FaultLimitExceeded once more than n bytes are read.
+type limitReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return 0, limitFault("message larger than %d bytes", l.max)
+	}
+	return n, err
+}
 
-	if !ret.Fault.IsEmpty() {
-		return getFaultResponse(ret.Fault)
+// DecodeMethodName reads a methodCall up to its method name and returns it.
+// The params are then decoded by Decode.
+func (d *Decoder) DecodeMethodName() (string, error) {
+	if err := d.start(); err != nil {
+		return "", err
+	}
+	for {
+		se, err := d.child()
+		if err != nil || se == nil {
+			return "", err
+		}
+		switch se.Name.Local {
+		case "methodName":
+			text, err := d.readText()
+			return string(text), err
+		case "params", "fault":
+			// No method name before the params; leave them to Decode.
+			d.next = se
+			return "", nil
+		}
+		if err := d.d.Skip(); err != nil {
+			return "", d.wrap(err)
+		}
 	}
+}
 
-	// Structures should have equal number of fields
-	if reflect.TypeOf(rpc).Elem().NumField() != len(ret.Params) {
+// Decode reads the params of a methodCall or methodResponse into rpc, a
+// pointer to a structure with a field per param. A fault response is
+// returned as Fault, or as FaultError if its code is registered in the
+// FaultRegistry of the options.
+//
+// Trailing fields tagged `xml:",optional"` are left unset when their params
+// are omitted, and a last slice field tagged `xml:",variadic"` takes the
+// params following the other fields.
+func (d *Decoder) Decode(rpc interface{}) error {
+	v := reflect.ValueOf(rpc).Elem()
+	required, variadic := paramLayout(v.Type())
+	n, err := d.decodeParams(func(i int) (reflect.Value, string, error) {
+		if variadic >= 0 && i >= variadic {
+			rest := v.Field(variadic)
+			rest.Set(reflect.Append(rest, reflect.Zero(rest.Type().Elem())))
+			name := fmt.Sprintf("%s[%d]", v.Type().Field(variadic).Name, rest.Len()-1)
+			return rest.Index(rest.Len() - 1), name, nil
+		}
+		if i >= v.NumField() {
+			return reflect.Value{}, "", FaultWrongArgumentsNumber
+		}
+		return v.Field(i), v.Type().Field(i).Name, nil
+	})
+	if err != nil {
+		return err
+	}
+	// Structures should have a field per required param
+	if n < required && !d.opts.decoder.AllowMissingParams {
 		return FaultWrongArgumentsNumber
 	}
+	return nil
+}
+
+// decodeRaw reads the params of a message as raw values.
+func (d *Decoder) decodeRaw() ([]rawValue, error) {
+	var params []*rawValue
+	_, err := d.decodeParams(func(i int) (reflect.Value, string, error) {
+		params = append(params, new(rawValue))
+		return reflect.ValueOf(params[i]).Elem(), "", nil
+	})
+	raws := make([]rawValue, len(params))
+	for i, p := range params {
+		raws[i] = *p
+	}
+	return raws, err
+}
 
-	// Now, convert temporal structure into the
-	// passed rpc variable, according to it's structure
-	for i, param := range ret.Params {
-		field := reflect.ValueOf(rpc).Elem().Field(i)
-		err = value2Field(param.Value, &field)
+// decodeParams decodes each param into the field returned for its index,
+// and returns the number of params. The name returned with the field is used
+// in the path of DecodeErrors; params without a name are indexed.
+func (d *Decoder) decodeParams(field func(i int) (reflect.Value, string, error)) (int, error) {
+	if err := d.start(); err != nil {
+		return 0, err
+	}
+	n := 0
+	for {
+		se, err := d.child()
 		if err != nil {
+			return n, err
+		}
+		if se == nil {
+			return n, nil
+		}
+		switch se.Name.Local {
+		case "params":
+			for {
+				p, err := d.element()
+				if err != nil {
+					return n, err
+				}
+				if p == nil {
+					break
+				}
+				if p.Name.Local != "param" {
+					if err := d.d.Skip(); err != nil {
+						return n, d.wrap(err)
+					}
+					continue
+				}
+				f, name, err := field(n)
+				if err != nil {
+					return n, err
+				}
+				if err := d.decodeParam(f); err != nil {
+					if name == "" {
+						return n, prefixPath(err, fmt.Sprintf("%s[%d]", d.root, n))
+					}
+					return n, prefixPath(err, d.root+"."+name)
+				}
+				n++
+			}
+		case "fault":
+			return n, d.decodeFault()
+		default:
+			if err := d.d.Skip(); err != nil {
+				return n, d.wrap(err)
+			}
+		}
+	}
+}
+
+// decodeFaultResponse decodes the fault of a response, and reports whether
+// the response holds a <fault> rather than params.
+func (d *Decoder) decodeFaultResponse() (bool, error) {
+	if err := d.start(); err != nil {
+		return false, err
+	}
+	for {
+		se, err := d.child()
+		if err != nil || se == nil {
+			return false, err
+		}
+		switch se.Name.Local {
+		case "fault":
+			return true, d.decodeFault()
+		case "params":
+			return false, nil
+		}
+		if err := d.d.Skip(); err != nil {
+			return false, d.wrap(err)
+		}
+	}
+}
+
+// start reads the root element of the message.
+func (d *Decoder) start() error {
+	if d.started {
+		return nil
+	}
+	se, err := d.element()
+	if err != nil {
+		return err
+	}
+	if se == nil {
+		return d.wrap(FaultDecode)
+	}
+	d.root = "Args"
+	if se.Name.Local == "methodResponse" {
+		d.root = "Reply"
+	}
+	d.started = true
+	return nil
+}
+
+// child returns the next child element of the root, or nil at its end.
+func (d *Decoder) child() (*xml.StartElement, error) {
+	if d.next != nil {
+		se := d.next
+		d.next = nil
+		return se, nil
+	}
+	if d.done {
+		return nil, nil
+	}
+	se, err := d.element()
+	if se == nil && err == nil {
+		d.done = true
+	}
+	return se, err
+}
+
+// decodeParam decodes the <value> of a <param>. An empty param leaves the
+// field unchanged.
+func (d *Decoder) decodeParam(field reflect.Value) error {
+	for {
+		se, err := d.element()
+		if err != nil || se == nil {
 			return err
 		}
+		if se.Name.Local != "value" {
+			if err := d.d.Skip(); err != nil {
+				return d.wrap(err)
+			}
+			continue
+		}
+		if err := d.decodeValue(field); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeFault decodes the <value> of a <fault> and returns it as Fault or
+// FaultError.
+func (d *Decoder) decodeFault() error {
+	var members map[string]interface{}
+	if err := d.decodeParam(reflect.ValueOf(&members).Elem()); err != nil {
+		return prefixPath(err, "Fault")
+	}
+	code, _ := members["faultCode"].(int)
+	str, _ := members["faultString"].(string)
+	fault := Fault{Code: code, String: str}
+	if d.opts.faults != nil {
+		return d.opts.faults.Error(fault)
 	}
+	return fault
+}
+
+// ----------------------------------------------------------------------------
+// Tokens
+// ----------------------------------------------------------------------------
 
+// token returns the next token, skipping comments, processing instructions
+// and directives.
+func (d *Decoder) token() (xml.Token, error) {
+	for {
+		tok, err := d.d.Token()
+		if err != nil {
+			return nil, d.wrap(err)
+		}
+		switch tok.(type) {
+		case xml.Comment, xml.ProcInst, xml.Directive:
+			continue
+		}
+		return tok, nil
+	}
+}
+
+// wrap converts a Fault into a DecodeError at the current position of the
+// input, and so do errors of malformed XML, as FaultDecode. Read errors are
+// returned as is.
+func (d *Decoder) wrap(err error) error {
+	var e *DecodeError
+	switch err := err.(type) {
+	case *DecodeError:
+		e = err
+	case Fault:
+		e = &DecodeError{Fault: err}
+	case *xml.SyntaxError:
+		e = &DecodeError{Fault: FaultDecode, Err: err}
+	default:
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		e = &DecodeError{Fault: FaultDecode, Err: io.ErrUnexpectedEOF}
+	}
+	if e.Line == 0 {
+		e.Offset = d.d.InputOffset()
+		e.Line, e.Column = d.d.InputPos()
+	}
+	return e
+}
+
+// invalidValue returns a DecodeError for err, returned while converting
+// the text of a value.
+func invalidValue(err error) error {
+	return &DecodeError{Fault: FaultInvalidParams, Err: err}
+}
+
+// element returns the next child element of the current element, or nil
+// once the current element ends. Text between elements is ignored.
+func (d *Decoder) element() (*xml.StartElement, error) {
+	for {
+		tok, err := d.token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return &t, nil
+		case xml.EndElement:
+			return nil, nil
+		}
+	}
+}
+
+// readText returns the text of the current element, up to its end.
+func (d *Decoder) readText() ([]byte, error) {
+	var text []byte
+	for {
+		tok, err := d.token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			text = append(text, t...)
+			if err := d.checkText(len(text)); err != nil {
+				return nil, err
+			}
+		case xml.StartElement:
+			return nil, d.wrap(FaultDecode)
+		case xml.EndElement:
+			return text, nil
+		}
+	}
+}
+
+// readBase64 decodes the base64 text of the current element, up to its end.
+// The text is streamed from the tokens of the XML decoder through a base64
+// decoder, instead of being gathered first, into a buffer sized from the
+// first token, which usually holds the whole text.
+func (d *Decoder) readBase64() ([]byte, error) {
+	r := &textReader{d: d}
+	if r.err = r.next(); r.err != nil && r.err != io.EOF {
+		return nil, r.err
+	}
+	data := bytes.NewBuffer(make([]byte, 0, base64.StdEncoding.DecodedLen(len(r.text))+bytes.MinRead))
+	if _, err := data.ReadFrom(base64.NewDecoder(base64.StdEncoding, r)); err != nil {
+		switch err.(type) {
+		case *DecodeError, Fault:
+			return nil, err
+		}
+		// Corrupt or truncated base64 data.
+		return nil, invalidValue(err)
+	}
+	return data.Bytes(), nil
+}
+
+// textReader reads the text of the current element, up to its end, straight
+// from the CharData tokens buffered by the XML decoder.
+type textReader struct {
+	d    *Decoder
+	text []byte
+	size int
+	err  error
+}
+
+func (r *textReader) Read(p []byte) (int, error) {
+	for len(r.text) == 0 && r.err == nil {
+		r.err = r.next()
+	}
+	if len(r.text) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.text)
+	r.text = r.text[n:]
+	return n, nil
+}
+
+// next reads the next token, returning io.EOF at the end of the element.
+func (r *textReader) next() error {
+	tok, err := r.d.token()
+	if err != nil {
+		return err
+	}
+	switch t := tok.(type) {
+	case xml.CharData:
+		r.size += len(t)
+		if err := r.d.checkText(r.size); err != nil {
+			return err
+		}
+		r.text = t
+	case xml.StartElement:
+		return r.d.wrap(FaultDecode)
+	case xml.EndElement:
+		return io.EOF
+	}
 	return nil
 }
 
-// getFaultResponse converts faultValue to Fault.
-func getFaultResponse(fault faultValue) Fault {
+// escaper escapes the text re-serialized by readRaw, whose characters the
+// XML decoder already checked.
+var escaper = strings.NewReplacer("&", "&amp;", "\"", "&quot;", "<", "&lt;", ">", "&gt;")
+
+// readRaw returns the content of the current element as XML, up to its end,
+// and the name of its first child element.
+func (d *Decoder) readRaw() ([]byte, string, error) {
 	var (
-		code int
-		str  string
+		raw   bytes.Buffer
+		first string
+		depth int
+		open  bool
 	)
-
-	for _, field := range fault.Value.Struct {
-		if field.Name == "faultCode" {
-			code, _ = strconv.Atoi(field.Value.Int)
-		} else if field.Name == "faultString" {
-			str = field.Value.String
-			if str == "" {
-				str = field.Value.Raw
+	for {
+		tok, err := d.token()
+		if err != nil {
+			return nil, "", err
+		}
+		if open {
+			if _, ok := tok.(xml.EndElement); ok {
+				raw.WriteString("/>")
+				open = false
+				depth--
+				continue
+			}
+			raw.WriteString(">")
+			open = false
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			if err := d.checkText(len(t)); err != nil {
+				return nil, "", err
 			}
+			escaper.WriteString(&raw, string(t))
+		case xml.StartElement:
+			if first == "" {
+				first = t.Name.Local
+			}
+			raw.WriteString("<" + qualifiedName(t.Name))
+			open = true
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				return raw.Bytes(), first, nil
+			}
+			raw.WriteString("</" + qualifiedName(t.Name) + ">")
+			depth--
 		}
 	}
+}
+
+// checkText checks the size of a text against MaxStringSize.
+func (d *Decoder) checkText(size int) error {
+	if max := d.opts.limits.MaxStringSize; max > 0 && size > max {
+		return limitFault("text longer than %d bytes", max)
+	}
+	return nil
+}
+
+// qualifiedName returns name with the "ex" prefix for vendor extensions.
+func qualifiedName(name xml.Name) string {
+	if name.Space == extensionsNamespace {
+		return "ex:" + name.Local
+	}
+	return name.Local
+}
 
-	return Fault{Code: code, String: str}
+// ----------------------------------------------------------------------------
+// Values
+// ----------------------------------------------------------------------------
+
+// Unmarshaler is the interface implemented by types that can unmarshal a
+// XML-RPC value of themselves.
+//
+// UnmarshalXMLRPC receives the raw content of the <value> element, such as
+// "<string>12.50 EUR</string>". It must copy the data if it wishes to retain
+// it after returning.
+type Unmarshaler interface {
+	UnmarshalXMLRPC(data []byte) error
+}
+
+var typeOfUnmarshaler = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+
+// isUnmarshaler reports whether field or its address implements Unmarshaler.
+func isUnmarshaler(field reflect.Value) bool {
+	if field.Kind() == reflect.Ptr && field.Type().Implements(typeOfUnmarshaler) {
+		return true
+	}
+	return field.CanAddr() && field.Addr().Type().Implements(typeOfUnmarshaler)
 }
 
-func fieldByXmlTagName(value *reflect.Value, match string) reflect.Value {
+// decodeUnmarshaler passes the raw value to the Unmarshaler of field,
+// allocating it if field is a nil pointer. <nil/> values decoded into
+// pointers set them to nil.
+func (d *Decoder) decodeUnmarshaler(field reflect.Value) error {
+	raw, first, err := d.readRaw()
+	if err != nil {
+		return err
+	}
+	if field.Kind() == reflect.Ptr && field.Type().Implements(typeOfUnmarshaler) {
+		if first == "nil" {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		err = field.Interface().(Unmarshaler).UnmarshalXMLRPC(raw)
+	} else {
+		err = field.Addr().Interface().(Unmarshaler).UnmarshalXMLRPC(raw)
+	}
+	if err != nil {
+		return invalidValue(err)
+	}
+	return nil
+}
+
+func fieldByXmlTagName(value *reflect.Value, match string) (reflect.Value, string) {
 	for i := 0; i < reflect.TypeOf(value.Interface()).NumField(); i++ {
 		field_type := reflect.TypeOf(value.Interface()).Field(i)
 		field_tag := parseXMLTag(field_type)
 		if field_tag.Name == match {
-			return value.Field(i)
+			return value.Field(i), field_type.Name
 		}
 	}
-	return reflect.Value{}
+	return reflect.Value{}, ""
 }
 
-func value2Field(value value, field *reflect.Value) error {
+// decodeValue decodes the current <value> element into field. Errors are
+// returned as DecodeErrors, noting the type of the value.
+func (d *Decoder) decodeValue(field reflect.Value) (err error) {
 	if !field.CanSet() {
-		return FaultApplicationError
+		return d.wrap(FaultApplicationError)
+	}
+	found := ""
+	d.depth++
+	defer func() {
+		d.depth--
+		if err == nil {
+			return
+		}
+		err = d.wrap(err)
+		if e, ok := err.(*DecodeError); ok && e.Expected == nil && found != "" {
+			e.Found, e.Expected = found, field.Type()
+		}
+	}()
+	if max := d.opts.limits.MaxDepth; max > 0 && d.depth > max {
+		return limitFault("values nested deeper than %d", max)
+	}
+	if isUnmarshaler(field) {
+		return d.decodeUnmarshaler(field)
 	}
 
+	var text []byte
+	for {
+		tok, err := d.token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			text = append(text, t...)
+			if err := d.checkText(len(text)); err != nil {
+				return err
+			}
+		case xml.StartElement:
+			found = t.Name.Local
+			if err := d.decodeType(t.Name.Local, field); err != nil {
+				return err
+			}
+			// Skip anything following the type element.
+			for {
+				se, err := d.element()
+				if err != nil || se == nil {
+					return err
+				}
+				if err := d.d.Skip(); err != nil {
+					return d.wrap(err)
+				}
+			}
+		case xml.EndElement:
+			// value field is default to string, see http://en.wikipedia.org/wiki/XML-RPC#Data_types
+			found = "string"
+			if ok, err := d.coerce("string", string(text), field); ok {
+				return err
+			}
+			return assign(string(text), field)
+		}
+	}
+}
+
+// extensionTypes are the Apache XML-RPC vendor extension types, see
+// WithExtensions.
+var extensionTypes = map[string]bool{
+	"i1": true, "i2": true, "i8": true,
+	"float": true, "biginteger": true, "bigdecimal": true,
+}
+
+// interfaceTypes are the Go types XML-RPC types are decoded into when the
+// target is an interface.
+var interfaceTypes = map[string]reflect.Type{
+	"int":              reflect.TypeOf(int(0)),
+	"i4":               reflect.TypeOf(int(0)),
+	"double":           reflect.TypeOf(float64(0)),
+	"string":           reflect.TypeOf(""),
+	"boolean":          reflect.TypeOf(false),
+	"dateTime.iso8601": typeOfTime,
+	"base64":           typeOfBytes,
+	"i1":               reflect.TypeOf(int8(0)),
+	"i2":               reflect.TypeOf(int16(0)),
+	"i8":               reflect.TypeOf(int64(0)),
+	"float":            reflect.TypeOf(float32(0)),
+	"biginteger":       reflect.TypeOf((*big.Int)(nil)),
+	"bigdecimal":       reflect.TypeOf((*big.Float)(nil)),
+	"struct":           reflect.TypeOf(map[string]interface{}(nil)),
+	"array":            reflect.TypeOf([]interface{}(nil)),
+}
+
+// decodeType decodes the current type element, such as <int>, into field.
+func (d *Decoder) decodeType(name string, field reflect.Value) error {
+	if name == "nil" {
+		switch field.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			field.Set(reflect.Zero(field.Type()))
+		}
+		if err := d.d.Skip(); err != nil {
+			return d.wrap(err)
+		}
+		return nil
+	}
+	if extensionTypes[name] && !d.opts.extensions {
+		fault := FaultInvalidParams
+		fault.String += ": vendor extension types require extensions"
+		return fault
+	}
+
+	switch field.Kind() {
+	case reflect.Interface:
+		// Choose the Go type from the XML-RPC type: <struct> becomes
+		// map[string]interface{}, <array> becomes []interface{}, etc.
+		t, ok := interfaceTypes[name]
+		if !ok {
+			return unknownTypeFault(name)
+		}
+		if !t.AssignableTo(field.Type()) {
+			return typeMismatchFault(t.String(), field.Type())
+		}
+		v := reflect.New(t).Elem()
+		if err := d.decodeType(name, v); err != nil {
+			return err
+		}
+		field.Set(v)
+		return nil
+	case reflect.Ptr:
+		// Assign as pointer to value type (pointer types are used for
+		// fields that are omitted when empty).
+		p := reflect.New(field.Type().Elem())
+		if err := d.decodeType(name, p.Elem()); err != nil {
+			return err
+		}
+		field.Set(p)
+		return nil
+	}
+
+	switch name {
+	case "struct":
+		return d.decodeStruct(field)
+	case "array":
+		return d.decodeArray(field)
+	case "base64":
+		val, err := d.readBase64()
+		if err != nil {
+			return err
+		}
+		return assign(val, field)
+	}
+
+	text, err := d.readText()
+	if err != nil {
+		return err
+	}
+	if ok, err := d.coerce(name, string(text), field); ok {
+		return err
+	}
+	switch name {
+	case "int", "i4", "i1", "i2", "i8":
+		return xml2Int(string(text), &field)
+	case "double":
+		return xml2Float(string(text), &field, d.opts.decoder.AllowExponents)
+	case "string":
+		return assign(string(text), field)
+	case "boolean":
+		return assign(xml2Bool(string(text)), field)
+	case "dateTime.iso8601":
+		val, err := xml2DateTime(string(text), d.opts.location)
+		if err != nil {
+			return invalidValue(err)
+		}
+		return assign(val, field)
+	case "float":
+		return xml2Float(string(text), &field, d.opts.decoder.AllowExponents)
+	case "biginteger":
+		return xml2BigInt(string(text), &field)
+	case "bigdecimal":
+		return xml2BigFloat(string(text), &field)
+	}
+	return unknownTypeFault(name)
+}
+
+// coerce decodes the text of a value into a field of another type, as
+// allowed by the DecoderOptions, and reports whether it did.
+func (d *Decoder) coerce(name, text string, field reflect.Value) (bool, error) {
+	switch field.Kind() {
+	case reflect.Float32, reflect.Float64:
+		switch {
+		case d.opts.decoder.WidenNumbers && isIntegerType(name):
+			n, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+			if err != nil {
+				fault := FaultInvalidParams
+				fault.String += fmt.Sprintf(": invalid integer %q", text)
+				return true, fault
+			}
+			field.SetFloat(float64(n))
+			return true, nil
+		case d.opts.decoder.CoerceStrings && name == "string":
+			return true, xml2Float(text, &field, d.opts.decoder.AllowExponents)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if d.opts.decoder.CoerceStrings && name == "string" {
+			return true, xml2Int(text, &field)
+		}
+	case reflect.Bool:
+		if d.opts.decoder.CoerceStrings && name == "string" {
+			b, err := strconv.ParseBool(strings.TrimSpace(text))
+			if err != nil {
+				fault := FaultInvalidParams
+				fault.String += fmt.Sprintf(": invalid boolean %q", text)
+				return true, fault
+			}
+			field.SetBool(b)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isIntegerType reports whether name is a XML-RPC integer type.
+func isIntegerType(name string) bool {
+	switch name {
+	case "int", "i4", "i1", "i2", "i8":
+		return true
+	}
+	return false
+}
+
+// decodeStruct decodes the members of the current <struct> into a structure
+// or a map with string keys.
+func (d *Decoder) decodeStruct(field reflect.Value) error {
+	switch field.Kind() {
+	case reflect.Struct:
+	case reflect.Map:
+		if field.Type().Key().Kind() != reflect.String {
+			return typeMismatchFault("struct", field.Type())
+		}
+		if field.IsNil() {
+			field.Set(reflect.MakeMap(field.Type()))
+		}
+	default:
+		return typeMismatchFault("struct", field.Type())
+	}
+
+	var seen map[string]bool
+	if d.opts.decoder.RejectDuplicateMembers {
+		seen = make(map[string]bool)
+	}
+	members := 0
+	for {
+		se, err := d.element()
+		if err != nil || se == nil {
+			return err
+		}
+		if se.Name.Local != "member" {
+			if err := d.d.Skip(); err != nil {
+				return d.wrap(err)
+			}
+			continue
+		}
+		members++
+		if max := d.opts.limits.MaxMembers; max > 0 && members > max {
+			return limitFault("struct with more than %d members", max)
+		}
+		if err := d.decodeMember(field, seen); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeMember decodes the current <member> into its field, or its map
+// entry. The name of the member must come before its value. Member names
+// are recorded in seen, if not nil, to reject duplicates.
+func (d *Decoder) decodeMember(field reflect.Value, seen map[string]bool) error {
 	var (
-		err error
-		val interface{}
+		name     string
+		haveName bool
 	)
-
-	switch {
-	case value.Int != "":
-		val, _ = strconv.Atoi(value.Int)
-	case value.Int4 != "":
-		val, _ = strconv.Atoi(value.Int4)
-	case value.Double != "":
-		val, _ = strconv.ParseFloat(value.Double, 64)
-	case value.String != "", value.Raw == "<string></string>":
-		val = value.String
-	case value.Boolean != "":
-		val = xml2Bool(value.Boolean)
-	case value.DateTime != "":
-		val, err = xml2DateTime(value.DateTime)
-	case value.Base64 != "":
-		val, err = xml2Base64(value.Base64)
-	case len(value.Struct) != 0:
-		if field.Kind() != reflect.Struct {
-			fault := FaultInvalidParams
-			fault.String += fmt.Sprintf("structure fields mismatch: %s != %s", field.Kind(), reflect.Struct.String())
-			return fault
-		}
-		s := value.Struct
-		for i := 0; i < len(s); i++ {
+	for {
+		se, err := d.element()
+		if err != nil || se == nil {
+			return err
+		}
+		switch se.Name.Local {
+		case "name":
+			text, err := d.readText()
+			if err != nil {
+				return err
+			}
+			name, haveName = string(text), true
+			if seen != nil {
+				if seen[name] {
+					fault := FaultInvalidParams
+					fault.String += ": duplicate member"
+					return d.wrap(&DecodeError{Fault: fault, Path: "." + name})
+				}
+				seen[name] = true
+			}
+		case "value":
+			if !haveName {
+				return FaultDecode
+			}
+			if field.Kind() == reflect.Map {
+				elem := reflect.New(field.Type().Elem()).Elem()
+				if err := d.decodeValue(elem); err != nil {
+					return prefixPath(err, fmt.Sprintf("[%q]", name))
+				}
+				field.SetMapIndex(reflect.ValueOf(name).Convert(field.Type().Key()), elem)
+				continue
+			}
 			// Uppercase first letter for field name to deal with
 			// methods in lowercase, which cannot be used
-			field_name := uppercaseFirst(s[i].Name)
-			f := field.FieldByName(field_name)
+			fieldName := uppercaseFirst(name)
+			f := field.FieldByName(fieldName)
 			if !f.IsValid() {
 				// Try to find the field by XML tag name
-				f = fieldByXmlTagName(field, s[i].Name)
+				f, fieldName = fieldByXmlTagName(&field, name)
 				if !f.IsValid() {
-					return FaultApplicationError
+					if err := d.decodeUnknownMember(field, name); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if err := d.decodeValue(f); err != nil {
+				return prefixPath(err, "."+fieldName)
+			}
+		default:
+			if err := d.d.Skip(); err != nil {
+				return d.wrap(err)
+			}
+		}
+	}
+}
+
+// decodeUnknownMember decodes the value of a member without a matching field
+// of the structure, following the UnknownMembers policy.
+func (d *Decoder) decodeUnknownMember(field reflect.Value, name string) error {
+	switch d.opts.unknown {
+	case IgnoreUnknownMembers:
+		if err := d.d.Skip(); err != nil {
+			return d.wrap(err)
+		}
+		return nil
+	case CaptureUnknownMembers:
+		if f, fieldName := anyField(field); f.IsValid() {
+			if f.IsNil() {
+				f.Set(reflect.MakeMap(f.Type()))
+			}
+			elem := reflect.New(f.Type().Elem()).Elem()
+			if err := d.decodeValue(elem); err != nil {
+				return prefixPath(err, fmt.Sprintf(".%s[%q]", fieldName, name))
+			}
+			f.SetMapIndex(reflect.ValueOf(name).Convert(f.Type().Key()), elem)
+			return nil
+		}
+	}
+	return d.wrap(&DecodeError{Fault: FaultApplicationError, Path: "." + name})
+}
+
+// anyField returns the field of a structure tagged `xml:",any"`, if it is a
+// map with string keys, and its name.
+func anyField(value reflect.Value) (reflect.Value, string) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.PkgPath != "" || !parseXMLTag(field).Any {
+			continue
+		}
+		if field.Type.Kind() == reflect.Map && field.Type.Key().Kind() == reflect.String {
+			return value.Field(i), field.Name
+		}
+	}
+	return reflect.Value{}, ""
+}
+
+// decodeArray appends the values of the current <array> to a slice field.
+func (d *Decoder) decodeArray(field reflect.Value) error {
+	if field.Kind() != reflect.Slice {
+		return typeMismatchFault("array", field.Type())
+	}
+	slice := field
+	if slice.IsNil() {
+		// Empty XML array decodes into an empty, not nil, slice
+		slice = reflect.MakeSlice(field.Type(), 0, 0)
+	}
+	items := 0
+	for {
+		se, err := d.element()
+		if err != nil {
+			return err
+		}
+		if se == nil {
+			break
+		}
+		if se.Name.Local != "data" {
+			if err := d.d.Skip(); err != nil {
+				return d.wrap(err)
+			}
+			continue
+		}
+		for {
+			item, err := d.element()
+			if err != nil {
+				return err
+			}
+			if item == nil {
+				break
+			}
+			if item.Name.Local != "value" {
+				if err := d.d.Skip(); err != nil {
+					return d.wrap(err)
 				}
+				continue
+			}
+			items++
+			if max := d.opts.limits.MaxArrayItems; max > 0 && items > max {
+				return limitFault("array with more than %d items", max)
+			}
+			slice = reflect.Append(slice, reflect.Zero(field.Type().Elem()))
+			if err := d.decodeValue(slice.Index(slice.Len() - 1)); err != nil {
+				return prefixPath(err, fmt.Sprintf("[%d]", slice.Len()-1))
 			}
-			err = value2Field(s[i].Value, &f)
 		}
-	case len(value.Array) != 0, value.Raw == "<array><data></data></array>":
-		a := value.Array
-		f := *field
-		slice := reflect.MakeSlice(reflect.TypeOf(f.Interface()),
-			len(a), len(a))
-		for i := 0; i < len(a); i++ {
-			item := slice.Index(i)
-			err = value2Field(a[i], &item)
+	}
+	field.Set(slice)
+	return nil
+}
+
+// assign sets val into a field of its type, or of a pointer to its type.
+func assign(val interface{}, field reflect.Value) error {
+	v := reflect.ValueOf(val)
+	switch {
+	case v.Type().AssignableTo(field.Type()):
+		field.Set(v)
+	case field.Kind() == reflect.Ptr && field.Type().Elem() == v.Type():
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		field.Set(p)
+	default:
+		return typeMismatchFault(v.Type().String(), field.Type())
+	}
+	return nil
+}
+
+// xml2Int parses a XML-RPC integer into an integer field of any width,
+// checking the value fits into it.
+func xml2Int(value string, field *reflect.Value) error {
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		fault := FaultInvalidParams
+		fault.String += fmt.Sprintf(": invalid integer %q", value)
+		return fault
+	}
+
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if field.OverflowInt(n) {
+			return intOverflowFault(n, field.Type())
 		}
-		f = reflect.AppendSlice(f, slice)
-		val = f.Interface()
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n < 0 || field.OverflowUint(uint64(n)) {
+			return intOverflowFault(n, field.Type())
+		}
+		field.SetUint(uint64(n))
 	default:
-		// value field is default to string, see http://en.wikipedia.org/wiki/XML-RPC#Data_types
-		// also can be <nil/>
-		if value.Raw != "<nil/>" {
-			val = value.Raw
-		}
-	}
-
-	if val != nil {
-		if reflect.TypeOf(val) != reflect.TypeOf(field.Interface()) {
-			if (reflect.ValueOf(field.Interface()).Kind() == reflect.Ptr) &&
-				(reflect.TypeOf(field.Interface()).Elem() == reflect.TypeOf(val)) {
-				// Assign as pointer to value type (pointer types are used for
-				// fields that are omitted when empty).
-				p := reflect.New(reflect.TypeOf(val))
-				p.Elem().Set(reflect.ValueOf(val))
-				val = p.Interface()
-			} else {
-				fault := FaultInvalidParams
-				fault.String += fmt.Sprintf(": fields type mismatch: %s != %s",
-					reflect.TypeOf(val),
-					reflect.TypeOf(field.Interface()))
-				return fault
+		return typeMismatchFault("int", field.Type())
+	}
+	return nil
+}
+
+func intOverflowFault(n int64, t reflect.Type) Fault {
+	fault := FaultInvalidParams
+	fault.String += fmt.Sprintf(": integer %d overflows %s", n, t)
+	return fault
+}
+
+// xml2Float parses a XML-RPC double or float into a float field. Exponents,
+// such as "1e-9", are only accepted if exponents is set; NaN and infinities
+// never are.
+func xml2Float(value string, field *reflect.Value, exponents bool) error {
+	if field.Kind() != reflect.Float32 && field.Kind() != reflect.Float64 {
+		return typeMismatchFault("float64", field.Type())
+	}
+	s := strings.TrimSpace(value)
+	f, err := strconv.ParseFloat(s, field.Type().Bits())
+	if err != nil || !isDecimal(s, exponents) {
+		fault := FaultInvalidParams
+		fault.String += fmt.Sprintf(": invalid double %q", value)
+		return fault
+	}
+	field.SetFloat(f)
+	return nil
+}
+
+// isDecimal reports whether s only holds the characters of a decimal number,
+// such as "-12.5", or of one with an exponent if exponents is set.
+func isDecimal(s string, exponents bool) bool {
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9', r == '.':
+		case r == '+' || r == '-':
+			if i > 0 && !(exponents && (s[i-1] == 'e' || s[i-1] == 'E')) {
+				return false
+			}
+		case r == 'e' || r == 'E':
+			if !exponents {
+				return false
 			}
+		default:
+			return false
 		}
+	}
+	return true
+}
 
-		field.Set(reflect.ValueOf(val))
+func xml2BigInt(value string, field *reflect.Value) error {
+	n, ok := new(big.Int).SetString(strings.TrimSpace(value), 10)
+	if !ok {
+		fault := FaultInvalidParams
+		fault.String += fmt.Sprintf(": invalid biginteger %q", value)
+		return fault
 	}
+	return setBig(reflect.ValueOf(n), field)
+}
 
-	return err
+func xml2BigFloat(value string, field *reflect.Value) error {
+	value = strings.TrimSpace(value)
+	// Keep at least as many bits as the decimal digits carry.
+	prec := uint(len(value)) * 4
+	if prec < 64 {
+		prec = 64
+	}
+	f, _, err := big.ParseFloat(value, 10, prec, big.ToNearestEven)
+	if err != nil {
+		fault := FaultInvalidParams
+		fault.String += fmt.Sprintf(": invalid bigdecimal %q", value)
+		return fault
+	}
+	return setBig(reflect.ValueOf(f), field)
+}
+
+// setBig sets p, a *big.Int or *big.Float, into a field of the pointer or
+// the value type.
+func setBig(p reflect.Value, field *reflect.Value) error {
+	switch field.Type() {
+	case p.Type():
+		field.Set(p)
+	case p.Type().Elem():
+		field.Set(p.Elem())
+	default:
+		return typeMismatchFault(p.Type().String(), field.Type())
+	}
+	return nil
+}
+
+func typeMismatchFault(found string, expected reflect.Type) Fault {
+	fault := FaultInvalidParams
+	fault.String += fmt.Sprintf(": fields type mismatch: %s != %s", found, expected)
+	return fault
+}
+
+func unknownTypeFault(name string) Fault {
+	fault := FaultInvalidParams
+	fault.String += fmt.Sprintf(": unknown value type <%s>", name)
+	return fault
 }
 
 func xml2Bool(value string) bool {
@@ -212,23 +1208,40 @@ func xml2Bool(value string) bool {
 	return b
 }
 
-func xml2DateTime(value string) (time.Time, error) {
-	var (
-		year, month, day     int
-		hour, minute, second int
-	)
-	_, err := fmt.Sscanf(value, "%04d%02d%02dT%02d:%02d:%02d",
-		&year, &month, &day,
-		&hour, &minute, &second)
-	t := time.Date(year, time.Month(month), day, hour, minute, second, 0, time.Local)
-	return t, err
+// dateTimeLayouts are the layouts of dateTime.iso8601 values accepted when
+// decoding. Fractional seconds are accepted by all layouts with seconds.
+var dateTimeLayouts = []string{
+	"20060102T15:04:05",
+	"20060102T15:04:05Z07:00",
+	"20060102T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05Z0700",
+	"20060102",
+	"2006-01-02",
 }
 
-func xml2Base64(value string) ([]byte, error) {
-	return base64.StdEncoding.DecodeString(value)
+// xml2DateTime parses a dateTime.iso8601 value. Values without a zone are
+// in loc, or time.Local if nil.
+func xml2DateTime(value string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	value = strings.TrimSpace(value)
+	for _, layout := range dateTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid dateTime.iso8601 %q", value)
 }
 
 func uppercaseFirst(in string) (out string) {
 	r, n := utf8.DecodeRuneInString(in)
 	return string(unicode.ToUpper(r)) + in[n:]
 }
+
+// xml2RPC decodes a XML-RPC message held in a string into rpc.
+func xml2RPC(xmlraw string, rpc interface{}, opts ...Option) error {
+	return NewDecoder(strings.NewReader(xmlraw), opts...).Decode(rpc)
+}
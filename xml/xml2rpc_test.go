@@ -5,9 +5,17 @@
 package xml
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
 	"math"
+	"math/big"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -178,7 +186,7 @@ type TestXml2RpcTagsTest struct {
 func TestXML2RPCTags(t *testing.T) {
 	var (
 		intVal            int = 42
-		smallestDoubleStr     = strconv.FormatFloat(math.SmallestNonzeroFloat64, 'E', -1, 64)
+		smallestDoubleStr     = strconv.FormatFloat(math.SmallestNonzeroFloat64, 'f', -1, 64)
 		tests                 = [...]TestXml2RpcTagsTest{
 			{
 				Input: "<methodCall>" +
@@ -249,7 +257,7 @@ func TestXML2RPCTags(t *testing.T) {
 	for i, test := range tests {
 		req := new(TaggedStructXml2Rpc)
 		err := xml2RPC(test.Input, req)
-		if err != test.err {
+		if (test.err == nil && err != nil) || !errors.Is(err, test.err) {
 			if test.err == nil {
 				t.Errorf("XML2RPC Tagged structure conversion test %d failed: %v", i, err)
 			} else {
@@ -264,3 +272,597 @@ func TestXML2RPCTags(t *testing.T) {
 		}
 	}
 }
+
+type StructIntegersXml2Rpc struct {
+	Int8   int8
+	Int16  int16
+	Int32  int32
+	Int64  int64
+	Uint   uint
+	Uint8  uint8
+	Uint16 uint16
+	Uint32 *uint32
+	Uint64 uint64
+	Status Status
+}
+
+func TestXML2RPCIntegers(t *testing.T) {
+	req := new(StructIntegersXml2Rpc)
+	err := xml2RPC("<methodResponse><params>"+
+		"<param><value><int>-8</int></value></param>"+
+		"<param><value><i4>-16</i4></value></param>"+
+		"<param><value><int>-32</int></value></param>"+
+		"<param><value><int>-2147483648</int></value></param>"+
+		"<param><value><int>1</int></value></param>"+
+		"<param><value><int>255</int></value></param>"+
+		"<param><value><i4>16</i4></value></param>"+
+		"<param><value><int>32</int></value></param>"+
+		"<param><value><int> 2147483647 </int></value></param>"+
+		"<param><value><int>7</int></value></param>"+
+		"</params></methodResponse>", req)
+	if err != nil {
+		t.Error("XML2RPC conversion failed", err)
+	}
+	var uint32Val uint32 = 32
+	expected_req := &StructIntegersXml2Rpc{-8, -16, -32, math.MinInt32, 1, 255, 16, &uint32Val, math.MaxInt32, Status(7)}
+	if !reflect.DeepEqual(req, expected_req) {
+		t.Error("XML2RPC conversion failed")
+		t.Error("Expected", expected_req)
+		t.Error("Got", req)
+	}
+}
+
+func TestXML2RPCIntegerOverflow(t *testing.T) {
+	tests := []struct {
+		Input string
+		Rpc   interface{}
+	}{
+		{"<int>128</int>", &struct{ Int8 int8 }{}},
+		{"<int>-1</int>", &struct{ Uint uint }{}},
+		{"<i4>70000</i4>", &struct{ Uint16 *uint16 }{}},
+		{"<int>12a</int>", &struct{ Int int }{}},
+	}
+	for i, test := range tests {
+		err := xml2RPC("<methodResponse><params><param><value>"+test.Input+"</value></param></params></methodResponse>", test.Rpc)
+		var fault Fault
+		if !errors.As(err, &fault) || fault.Code != FaultInvalidParams.Code {
+			t.Errorf("test %d: expected FaultInvalidParams, but got: %v", i, err)
+		}
+	}
+}
+
+type StructExtensionsXml2Rpc struct {
+	Int8    int8
+	Int16   int16
+	Int64   int64
+	Float32 float32
+	BigInt  *big.Int
+	BigDec  big.Float
+}
+
+func TestXML2RPCExtensions(t *testing.T) {
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<methodResponse xmlns:ex="http://ws.apache.org/xmlrpc/namespaces/extensions"><params>` +
+		"<param><value><ex:i1>-8</ex:i1></value></param>" +
+		"<param><value><i2>16</i2></value></param>" +
+		"<param><value><ex:i8>1099511627776</ex:i8></value></param>" +
+		"<param><value><ex:float>1.5</ex:float></value></param>" +
+		"<param><value><ex:biginteger>123456789012345678901234567890</ex:biginteger></value></param>" +
+		"<param><value><ex:bigdecimal>0.25</ex:bigdecimal></value></param>" +
+		"</params></methodResponse>"
+
+	req := new(StructExtensionsXml2Rpc)
+	if err := xml2RPC(data, req, WithExtensions()); err != nil {
+		t.Fatal("XML2RPC conversion failed", err)
+	}
+	bigInt, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	if req.Int8 != -8 || req.Int16 != 16 || req.Int64 != 1<<40 || req.Float32 != 1.5 ||
+		req.BigInt.Cmp(bigInt) != 0 || req.BigDec.Cmp(big.NewFloat(0.25)) != 0 {
+		t.Error("XML2RPC extensions conversion failed")
+		t.Error("Got", req)
+	}
+
+	err := xml2RPC(data, new(StructExtensionsXml2Rpc))
+	var fault Fault
+	if !errors.As(err, &fault) || fault.Code != FaultInvalidParams.Code {
+		t.Errorf("expected FaultInvalidParams without extensions, but got: %v", err)
+	}
+}
+
+type StructDynamicXml2Rpc struct {
+	Result interface{}
+}
+
+func TestXML2RPCDynamic(t *testing.T) {
+	req := new(StructDynamicXml2Rpc)
+	err := xml2RPC("<methodResponse><params><param><value><struct>"+
+		"<member><name>int</name><value><int>42</int></value></member>"+
+		"<member><name>double</name><value><double>1.5</double></value></member>"+
+		"<member><name>string</name><value><string>foo</string></value></member>"+
+		"<member><name>raw</name><value>bar</value></member>"+
+		"<member><name>bool</name><value><boolean>1</boolean></value></member>"+
+		"<member><name>time</name><value><dateTime.iso8601>20120717T14:08:55</dateTime.iso8601></value></member>"+
+		"<member><name>base64</name><value><base64>eW91IGNhbid0IHJlYWQgdGhpcyE=</base64></value></member>"+
+		"<member><name>nil</name><value><nil/></value></member>"+
+		"<member><name>array</name><value><array><data><value><int>1</int></value><value><string>two</string></value></data></array></value></member>"+
+		"<member><name>empty</name><value><array><data></data></array></value></member>"+
+		"<member><name>struct</name><value><struct><member><name>a</name><value><i4>1</i4></value></member></struct></value></member>"+
+		"</struct></value></param></params></methodResponse>", req)
+	if err != nil {
+		t.Fatal("XML2RPC conversion failed", err)
+	}
+	expected := map[string]interface{}{
+		"int":    42,
+		"double": 1.5,
+		"string": "foo",
+		"raw":    "bar",
+		"bool":   true,
+		"time":   time.Date(2012, time.July, 17, 14, 8, 55, 0, time.Local),
+		"base64": []byte("you can't read this!"),
+		"nil":    nil,
+		"array":  []interface{}{1, "two"},
+		"empty":  []interface{}{},
+		"struct": map[string]interface{}{"a": 1},
+	}
+	if !reflect.DeepEqual(req.Result, expected) {
+		t.Error("XML2RPC conversion failed")
+		t.Error("Expected", expected)
+		t.Error("Got", req.Result)
+	}
+}
+
+type StructMapXml2Rpc struct {
+	Labels map[string]string
+	Items  []interface{}
+}
+
+func TestXML2RPCMap(t *testing.T) {
+	req := new(StructMapXml2Rpc)
+	err := xml2RPC("<methodResponse><params>"+
+		"<param><value><struct><member><name>env</name><value>prod</value></member><member><name>team</name><value><string>core</string></value></member></struct></value></param>"+
+		"<param><value><array><data><value><struct><member><name>id</name><value><int>1</int></value></member></struct></value></data></array></value></param>"+
+		"</params></methodResponse>", req)
+	if err != nil {
+		t.Fatal("XML2RPC conversion failed", err)
+	}
+	expected := &StructMapXml2Rpc{
+		Labels: map[string]string{"env": "prod", "team": "core"},
+		Items:  []interface{}{map[string]interface{}{"id": 1}},
+	}
+	if !reflect.DeepEqual(req, expected) {
+		t.Error("XML2RPC conversion failed")
+		t.Error("Expected", expected)
+		t.Error("Got", req)
+	}
+
+	err = xml2RPC("<methodResponse><params>"+
+		"<param><value><struct><member><name>env</name><value><int>1</int></value></member></struct></value></param>"+
+		"<param><value><array><data></data></array></value></param>"+
+		"</params></methodResponse>", new(StructMapXml2Rpc))
+	var fault Fault
+	if !errors.As(err, &fault) || fault.Code != FaultInvalidParams.Code {
+		t.Errorf("expected FaultInvalidParams, but got: %v", err)
+	}
+}
+
+// UUID unmarshals itself from a <string> with a pointer receiver.
+type UUID [16]byte
+
+func (u *UUID) UnmarshalXMLRPC(data []byte) error {
+	var s string
+	if err := xml.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil || len(b) != len(u) {
+		return fmt.Errorf("invalid UUID %q", s)
+	}
+	copy(u[:], b)
+	return nil
+}
+
+type StructUnmarshalerXml2Rpc struct {
+	ID    UUID
+	Ptr   *UUID
+	Nil   *UUID
+	Items []UUID
+}
+
+func TestXML2RPCUnmarshaler(t *testing.T) {
+	const id = "<string>0123456789abcdef-0123456789abcdef</string>"
+	req := &StructUnmarshalerXml2Rpc{Nil: new(UUID)}
+	err := xml2RPC("<methodResponse><params>"+
+		"<param><value>"+id+"</value></param>"+
+		"<param><value>"+id+"</value></param>"+
+		"<param><value><nil/></value></param>"+
+		"<param><value><array><data><value>"+id+"</value></data></array></value></param>"+
+		"</params></methodResponse>", req)
+	if err != nil {
+		t.Fatal("XML2RPC conversion failed", err)
+	}
+	expected := UUID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	expected_req := &StructUnmarshalerXml2Rpc{expected, &expected, nil, []UUID{expected}}
+	if !reflect.DeepEqual(req, expected_req) {
+		t.Error("XML2RPC conversion failed")
+		t.Error("Expected", expected_req)
+		t.Error("Got", req)
+	}
+
+	err = xml2RPC("<methodResponse><params><param><value><string>nope</string></value></param></params></methodResponse>", new(struct{ ID UUID }))
+	if err == nil || !strings.Contains(err.Error(), "invalid UUID") {
+		t.Errorf("expected Unmarshaler error, but got: %v", err)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	data := bytes.Repeat([]byte("you can't read this!"), 1<<16)
+	body := "<?xml version=\"1.0\"?>\n<methodCall>\n  <methodName>Some.Method</methodName>\n  <params>\n" +
+		"    <param><value><base64>" + base64.StdEncoding.EncodeToString(data) + "</base64></value></param>\n" +
+		"    <param><value><!-- comment --><string>Hello</string></value></param>\n" +
+		"  </params>\n</methodCall>"
+
+	d := NewDecoder(strings.NewReader(body))
+	method, err := d.DecodeMethodName()
+	if err != nil || method != "Some.Method" {
+		t.Fatalf("DecodeMethodName: %q, %v", method, err)
+	}
+	var req struct {
+		Data []byte
+		Str  string
+	}
+	if err := d.Decode(&req); err != nil {
+		t.Fatal("Decode failed", err)
+	}
+	if !bytes.Equal(req.Data, data) || req.Str != "Hello" {
+		t.Error("Decode failed, got", len(req.Data), req.Str)
+	}
+
+	err = NewDecoder(strings.NewReader("<methodCall><methodName>Some.Method</methodName><params><param>")).Decode(&req)
+	if !errors.Is(err, FaultDecode) {
+		t.Error("Expected FaultDecode for truncated body, got", err)
+	}
+}
+
+func TestXML2RPCLimits(t *testing.T) {
+	nested := strings.Repeat("<value><array><data>", 20) + strings.Repeat("</data></array></value>", 20)
+	tests := []struct {
+		Limits Limits
+		Input  string
+	}{
+		{Limits{MaxBytes: 100}, "<value><string>" + strings.Repeat("a", 100) + "</string></value>"},
+		{Limits{MaxDepth: 10}, nested},
+		{Limits{MaxArrayItems: 2}, "<value><array><data><value>1</value><value>2</value><value>3</value></data></array></value>"},
+		{Limits{MaxMembers: 1}, "<value><struct><member><name>a</name><value>1</value></member><member><name>b</name><value>2</value></member></struct></value>"},
+		{Limits{MaxStringSize: 8}, "<value><string>123456789</string></value>"},
+		{Limits{MaxStringSize: 8}, "<value><base64>MTIzNDU2Nzg5</base64></value>"},
+	}
+	for i, test := range tests {
+		req := new(StructDynamicXml2Rpc)
+		err := xml2RPC("<methodResponse><params><param>"+test.Input+"</param></params></methodResponse>", req, WithLimits(test.Limits))
+		var fault Fault
+		if !errors.As(err, &fault) || fault.Code != FaultLimitExceeded.Code {
+			t.Errorf("test %d: expected FaultLimitExceeded, but got: %v", i, err)
+		}
+	}
+
+	req := new(StructDynamicXml2Rpc)
+	err := xml2RPC("<methodResponse><params><param>"+nested+"</param></params></methodResponse>", req, WithLimits(Limits{MaxDepth: 20, MaxStringSize: 8}))
+	if err != nil {
+		t.Error("expected nested values within limits to decode, but got:", err)
+	}
+}
+
+type StructDecodeErrorXml2Rpc struct {
+	Items []struct {
+		Name  string
+		Price int
+	}
+}
+
+func TestXML2RPCDecodeError(t *testing.T) {
+	data := `<?xml version="1.0"?>
+<methodCall><methodName>Some.Method</methodName><params><param><value><array><data>
+<value><struct><member><name>name</name><value>a</value></member><member><name>price</name><value><int>1</int></value></member></struct></value>
+<value><struct><member><name>price</name><value><string>2</string></value></member></struct></value>
+</data></array></value></param></params></methodCall>`
+	err := xml2RPC(data, new(StructDecodeErrorXml2Rpc))
+	e, ok := err.(*DecodeError)
+	if !ok {
+		t.Fatal("expected DecodeError, but got:", err)
+	}
+	if e.Path != "Args.Items[1].Price" || e.Found != "string" || e.Expected != reflect.TypeOf(0) {
+		t.Errorf("wrong path or types: %q, %q, %v", e.Path, e.Found, e.Expected)
+	}
+	if e.Line != 4 || e.Offset == 0 {
+		t.Errorf("wrong position: line %d, offset %d", e.Line, e.Offset)
+	}
+	if e.Fault.Code != FaultInvalidParams.Code {
+		t.Error("expected FaultInvalidParams, but got:", e.Fault)
+	}
+	if fault := e.AsFault(); !strings.Contains(fault.String, "Args.Items[1].Price (line 4") {
+		t.Error("expected fault to locate the error, but got:", fault.String)
+	}
+
+	err = xml2RPC("<methodCall><params><param><value><array><data><value><struct>"+
+		"<member><name>nope</name><value>1</value></member>"+
+		"</struct></value></data></array></value></param></params></methodCall>", new(StructDecodeErrorXml2Rpc))
+	if e, ok := err.(*DecodeError); !ok || e.Path != "Args.Items[0].nope" || !errors.Is(err, FaultApplicationError) {
+		t.Error("expected DecodeError for unknown member, but got:", err)
+	}
+}
+
+type StructUnknownMembersXml2Rpc struct {
+	Item struct {
+		Name  string
+		Extra map[string]interface{} `xml:",any"`
+	}
+}
+
+func TestXML2RPCUnknownMembers(t *testing.T) {
+	data := "<methodResponse><params><param><value><struct>" +
+		"<member><name>name</name><value>a</value></member>" +
+		"<member><name>color</name><value>red</value></member>" +
+		"<member><name>size</name><value><int>2</int></value></member>" +
+		"</struct></value></param></params></methodResponse>"
+
+	err := xml2RPC(data, new(StructUnknownMembersXml2Rpc))
+	if e, ok := err.(*DecodeError); !ok || e.Path != "Reply.Item.color" {
+		t.Error("expected unknown members to be rejected, but got:", err)
+	}
+
+	req := new(StructUnknownMembersXml2Rpc)
+	if err := xml2RPC(data, req, WithUnknownMembers(IgnoreUnknownMembers)); err != nil {
+		t.Fatal("expected unknown members to be ignored, but got:", err)
+	}
+	if req.Item.Name != "a" || req.Item.Extra != nil {
+		t.Error("wrong struct with ignored members:", req.Item)
+	}
+
+	// Decoder options leave the policy alone.
+	err = xml2RPC(data, new(StructUnknownMembersXml2Rpc),
+		WithUnknownMembers(IgnoreUnknownMembers), WithDecoderOptions(DecoderOptions{WidenNumbers: true}))
+	if err != nil {
+		t.Error("expected unknown members to be ignored with decoder options, but got:", err)
+	}
+
+	req = new(StructUnknownMembersXml2Rpc)
+	if err := xml2RPC(data, req, WithUnknownMembers(CaptureUnknownMembers)); err != nil {
+		t.Fatal("expected unknown members to be captured, but got:", err)
+	}
+	expected := map[string]interface{}{"color": "red", "size": 2}
+	if req.Item.Name != "a" || !reflect.DeepEqual(req.Item.Extra, expected) {
+		t.Error("wrong struct with captured members:", req.Item)
+	}
+	// Captured members are encoded back inline.
+	xml, err := rpcResponse2XML(req)
+	if err != nil {
+		t.Fatal("expected encoding to succeed, but got:", err)
+	}
+	expectedXML := "<methodResponse><params><param><value><struct>" +
+		"<member><name>Name</name><value><string>a</string></value></member>" +
+		"<member><name>color</name><value><string>red</string></value></member>" +
+		"<member><name>size</name><value><int>2</int></value></member>" +
+		"</struct></value></param></params></methodResponse>"
+	if xml != expectedXML {
+		t.Error("Expected", expectedXML)
+		t.Error("Got", xml)
+	}
+
+	// Without a catch-all field, unknown members are rejected.
+	err = xml2RPC(data, new(struct{ Item struct{ Name string } }), WithUnknownMembers(CaptureUnknownMembers))
+	if !errors.Is(err, FaultApplicationError) {
+		t.Error("expected FaultApplicationError, but got:", err)
+	}
+}
+
+func TestXML2RPCNestedErrors(t *testing.T) {
+	// A bad first item must not be hidden by the following ones.
+	err := xml2RPC("<methodCall><params><param><value><array><data>"+
+		"<value><struct><member><name>price</name><value>x</value></member></struct></value>"+
+		"<value><struct><member><name>price</name><value><int>2</int></value></member></struct></value>"+
+		"</data></array></value></param></params></methodCall>", new(StructDecodeErrorXml2Rpc))
+	if e, ok := err.(*DecodeError); !ok || e.Path != "Args.Items[0].Price" {
+		t.Error("expected DecodeError for the first item, but got:", err)
+	}
+}
+
+type StructDecoderOptionsXml2Rpc struct {
+	Float float64
+	Int   int64
+	Bool  bool
+	Ptr   *float32
+}
+
+func TestXML2RPCDecoderOptions(t *testing.T) {
+	data := "<methodResponse><params>" +
+		"<param><value><int>1</int></value></param>" +
+		"<param><value><string> 2 </string></value></param>" +
+		"<param><value>1</value></param>" +
+		"<param><value><i4>3</i4></value></param>" +
+		"</params></methodResponse>"
+
+	var fault Fault
+	if err := xml2RPC(data, new(StructDecoderOptionsXml2Rpc)); !errors.As(err, &fault) || fault.Code != FaultInvalidParams.Code {
+		t.Error("expected FaultInvalidParams without coercion, but got:", err)
+	}
+
+	req := new(StructDecoderOptionsXml2Rpc)
+	err := xml2RPC(data, req, WithDecoderOptions(DecoderOptions{WidenNumbers: true, CoerceStrings: true}))
+	if err != nil {
+		t.Fatal("expected coercion to succeed, but got:", err)
+	}
+	if req.Float != 1 || req.Int != 2 || !req.Bool || req.Ptr == nil || *req.Ptr != 3 {
+		t.Error("wrong coerced struct:", req)
+	}
+
+	err = xml2RPC("<methodResponse><params><param><value><string>yes</string></value></param></params></methodResponse>",
+		new(struct{ Bool bool }), WithDecoderOptions(DecoderOptions{CoerceStrings: true}))
+	if !errors.As(err, &fault) || fault.Code != FaultInvalidParams.Code {
+		t.Error("expected FaultInvalidParams for invalid boolean, but got:", err)
+	}
+
+	// Missing params.
+	data = "<methodResponse><params><param><value><double>1.5</double></value></param></params></methodResponse>"
+	if err := xml2RPC(data, new(StructDecoderOptionsXml2Rpc)); err != FaultWrongArgumentsNumber {
+		t.Error("expected FaultWrongArgumentsNumber, but got:", err)
+	}
+	req = new(StructDecoderOptionsXml2Rpc)
+	if err := xml2RPC(data, req, WithDecoderOptions(DecoderOptions{AllowMissingParams: true})); err != nil || req.Float != 1.5 {
+		t.Error("expected missing params to be allowed, but got:", err, req)
+	}
+
+	// Duplicate members.
+	data = "<methodResponse><params><param><value><struct>" +
+		"<member><name>name</name><value>a</value></member>" +
+		"<member><name>name</name><value>b</value></member>" +
+		"</struct></value></param></params></methodResponse>"
+	var res struct{ Item struct{ Name string } }
+	if err := xml2RPC(data, &res); err != nil || res.Item.Name != "b" {
+		t.Error("expected last duplicate member to be kept, but got:", err, res)
+	}
+	err = xml2RPC(data, &res, WithDecoderOptions(DecoderOptions{RejectDuplicateMembers: true}))
+	if e, ok := err.(*DecodeError); !ok || e.Path != "Reply.Item.name" {
+		t.Error("expected DecodeError for duplicate member, but got:", err)
+	}
+}
+
+func TestXML2RPCDateTime(t *testing.T) {
+	tz := time.FixedZone("CET", 3600)
+	tests := []struct {
+		Input    string
+		Expected time.Time
+	}{
+		{"20120717T14:08:55", time.Date(2012, time.July, 17, 14, 8, 55, 0, tz)},
+		{"2012-07-17T14:08:55", time.Date(2012, time.July, 17, 14, 8, 55, 0, tz)},
+		{"20120717T14:08:55Z", time.Date(2012, time.July, 17, 14, 8, 55, 0, time.UTC)},
+		{"2012-07-17T14:08:55-05:00", time.Date(2012, time.July, 17, 19, 8, 55, 0, time.UTC)},
+		{"20120717T14:08:55+0200", time.Date(2012, time.July, 17, 12, 8, 55, 0, time.UTC)},
+		{"2012-07-17T14:08:55.250Z", time.Date(2012, time.July, 17, 14, 8, 55, 250e6, time.UTC)},
+		{" 20120717 ", time.Date(2012, time.July, 17, 0, 0, 0, 0, tz)},
+		{"2012-07-17", time.Date(2012, time.July, 17, 0, 0, 0, 0, tz)},
+	}
+	for i, test := range tests {
+		var res struct{ Time time.Time }
+		err := xml2RPC("<methodResponse><params><param><value><dateTime.iso8601>"+test.Input+
+			"</dateTime.iso8601></value></param></params></methodResponse>", &res, WithDateTimeLocation(tz))
+		if err != nil {
+			t.Errorf("test %d: expected err to be nil, but got: %v", i, err)
+		} else if !res.Time.Equal(test.Expected) {
+			t.Errorf("test %d: expected %v, but got %v", i, test.Expected, res.Time)
+		}
+	}
+
+	var res struct{ Time time.Time }
+	err := xml2RPC("<methodResponse><params><param><value><dateTime.iso8601>17/07/2012</dateTime.iso8601></value></param></params></methodResponse>", &res)
+	var fault Fault
+	if !errors.As(err, &fault) || fault.Code != FaultInvalidParams.Code {
+		t.Error("expected FaultInvalidParams for invalid dateTime, but got:", err)
+	}
+}
+
+func TestXML2RPCFloats(t *testing.T) {
+	decode := func(input string, opts ...Option) (float64, float32, error) {
+		var res struct {
+			Double float64
+			Float  float32
+		}
+		err := xml2RPC("<methodResponse><params>"+
+			"<param><value><double>"+input+"</double></value></param>"+
+			"<param><value><double>"+input+"</double></value></param>"+
+			"</params></methodResponse>", &res, opts...)
+		return res.Double, res.Float, err
+	}
+
+	for _, input := range []string{"0.000000001", " -12.5 ", "+3", ".5"} {
+		expected, _ := strconv.ParseFloat(strings.TrimSpace(input), 64)
+		double, float, err := decode(input)
+		if err != nil || double != expected || float != float32(expected) {
+			t.Errorf("%q: wrong floats %v and %v, err: %v", input, double, float, err)
+		}
+	}
+
+	for _, input := range []string{"1e-9", "1.5E+3", "NaN", "Inf", "-Infinity", "0x1p-2", "1-2", ""} {
+		var fault Fault
+		if _, _, err := decode(input); !errors.As(err, &fault) || fault.Code != FaultInvalidParams.Code {
+			t.Errorf("%q: expected FaultInvalidParams, but got: %v", input, err)
+		}
+	}
+
+	opts := WithDecoderOptions(DecoderOptions{AllowExponents: true})
+	if double, float, err := decode("1.5E+3", opts); err != nil || double != 1500 || float != 1500 {
+		t.Errorf("expected exponent to be accepted, but got %v and %v, err: %v", double, float, err)
+	}
+	for _, input := range []string{"NaN", "1e400"} {
+		if _, _, err := decode(input, opts); err == nil {
+			t.Errorf("%q: expected error with exponents, but got nil", input)
+		}
+	}
+}
+
+func TestXML2RPCCDATA(t *testing.T) {
+	var res struct {
+		Typed   string
+		Untyped string
+	}
+	err := xml2RPC("<methodResponse><params>"+
+		"<param><value><string><![CDATA[<b>bold</b> & more]]></string></value></param>"+
+		"<param><value>a <![CDATA[<i>]]> b</value></param>"+
+		"</params></methodResponse>", &res)
+	if err != nil {
+		t.Fatal("expected err to be nil, but got:", err)
+	}
+	if res.Typed != "<b>bold</b> & more" || res.Untyped != "a <i> b" {
+		t.Errorf("wrong CDATA strings: %q, %q", res.Typed, res.Untyped)
+	}
+
+	var raw struct{ Value rawValue }
+	err = xml2RPC("<methodResponse><params><param><value><string><![CDATA[a<b]]></string></value></param></params></methodResponse>", &raw)
+	if err != nil || raw.Value != "<string>a&lt;b</string>" {
+		t.Errorf("wrong raw CDATA value %q, err: %v", raw.Value, err)
+	}
+}
+
+func TestXML2RPCBase64(t *testing.T) {
+	tests := []struct {
+		Input string
+		Data  string
+	}{
+		{"eW91IGNhbid0IHJlYWQgdGhpcyE=", "you can't read this!"},
+		{"eW91IGNh<!-- comment -->bid0IHJl<!-- comment -->YWQgdGhpcyE=", "you can't read this!"},
+		{"eW9<!-- comment -->1IGNhbid0IHJlYWQgdGhp<!-- comment -->cyE=", "you can't read this!"},
+		{"eW91IGNhbid0\nIHJlY\r\nWQgdGhpcyE=\n", "you can't read this!"},
+		{"eW91<![CDATA[IGNhbid0IHJlYWQgdGhpcyE=]]>", "you can't read this!"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		var res struct{ Data []byte }
+		err := xml2RPC("<methodResponse><params><param><value><base64>"+test.Input+"</base64></value></param></params></methodResponse>", &res)
+		if err != nil {
+			t.Errorf("%q: expected err to be nil, but got: %v", test.Input, err)
+		} else if res.Data == nil || string(res.Data) != test.Data {
+			t.Errorf("%q: expected %q, but got %q", test.Input, test.Data, res.Data)
+		}
+	}
+
+	for _, input := range []string{"eW91IGNhbid0IHJlYWQgdGhpcyE", "QQ==QUFB", "eW9*", "eW91<i>IGNh</i>"} {
+		var res struct{ Data []byte }
+		err := xml2RPC("<methodResponse><params><param><value><base64>"+input+"</base64></value></param></params></methodResponse>", &res)
+		if err == nil {
+			t.Errorf("%q: expected error, but got %q", input, res.Data)
+		}
+	}
+}
+
+func BenchmarkDecoderBase64(b *testing.B) {
+	data := bytes.Repeat([]byte("you can't read this!"), 1<<16)
+	body := "<methodResponse><params><param><value><base64>" + base64.StdEncoding.EncodeToString(data) +
+		"</base64></value></param></params></methodResponse>"
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var res struct{ Data []byte }
+		if err := NewDecoder(strings.NewReader(body)).Decode(&res); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
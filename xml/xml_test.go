@@ -6,9 +6,11 @@ package xml
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/maddogwg/rpc/v2"
@@ -220,3 +222,170 @@ func TestServices(t *testing.T) {
 		t.Errorf("Wrong response: %v.", res3.Info)
 	}
 }
+
+func TestCodecRequestXML(t *testing.T) {
+	body, _ := EncodeClientRequest("Service1.Multiply", &Service1Request{4, 2})
+	for _, capture := range []bool{false, true} {
+		var opts []Option
+		if capture {
+			opts = append(opts, WithRequestCapture())
+		}
+		r, _ := http.NewRequest("POST", "http://localhost:8080/", bytes.NewReader(body))
+		c := NewCodec(opts...).NewRequest(r).(*CodecRequest)
+		var req Service1Request
+		if err := c.ReadRequest(&req); err != nil {
+			t.Fatal("Expected err to be nil, but got:", err)
+		}
+
+		expected := ""
+		if capture {
+			expected = string(body)
+		}
+		if c.RequestXML() != expected {
+			t.Errorf("capture %v: expected RequestXML %q, but got %q", capture, expected, c.RequestXML())
+		}
+	}
+}
+
+func TestCodecResponseXML(t *testing.T) {
+	body, _ := EncodeClientRequest("Service1.Multiply", &Service1Request{4, 2})
+	for _, capture := range []bool{false, true} {
+		var opts []Option
+		if capture {
+			opts = append(opts, WithResponseCapture())
+		}
+		r, _ := http.NewRequest("POST", "http://localhost:8080/", bytes.NewReader(body))
+		c := NewCodec(opts...).NewRequest(r).(*CodecRequest)
+		var req Service1Request
+		if err := c.ReadRequest(&req); err != nil {
+			t.Fatal("Expected err to be nil, but got:", err)
+		}
+		w := httptest.NewRecorder()
+		c.WriteResponse(w, &Service1Response{req.A * req.B})
+
+		expected := ""
+		if capture {
+			expected = w.Body.String()
+		}
+		if c.ResponseXML() != expected {
+			t.Errorf("capture %v: expected ResponseXML %q, but got %q", capture, expected, c.ResponseXML())
+		}
+	}
+}
+
+//////////////////////////////////
+// Service 4
+//////////////////////////////////
+type Service4Request struct {
+	A int64
+	B int64
+}
+
+type Service4Response struct {
+	Result int64
+}
+
+type Service4 struct {
+}
+
+func (t *Service4) Multiply(r *http.Request, req *Service4Request, res *Service4Response) error {
+	res.Result = req.A * req.B
+	return nil
+}
+
+func TestServicesExtensions(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(WithExtensions()), "text/xml")
+	s.RegisterService(new(Service4), "")
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	var res Service4Response
+	c := NewClient(ts.URL, nil, WithExtensions())
+	if err := c.Call(context.Background(), "Service4.Multiply", &Service4Request{1 << 20, 1 << 20}, &res); err != nil {
+		t.Fatal("Expected err to be nil, but got:", err)
+	}
+	if res.Result != 1<<40 {
+		t.Errorf("Wrong response: %v.", res.Result)
+	}
+}
+
+func TestServicesEncodeError(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "text/xml")
+	s.RegisterService(new(Service4), "")
+
+	var res Service4Response
+	err := execute(t, s, "Service4.Multiply", &Service4Request{1 << 20, 1 << 20}, &res)
+	fault, ok := err.(Fault)
+	if !ok || fault.Code != FaultInternalError.Code || !strings.Contains(fault.String, "Reply.Result") {
+		t.Errorf("expected FaultInternalError for Reply.Result, but got: %v", err)
+	}
+}
+
+type Service5Request struct {
+	A      int
+	B      int   `xml:",optional"`
+	Others []int `xml:",variadic"`
+}
+
+type Service5Response struct {
+	Result int
+}
+
+type Service5 struct {
+}
+
+func (t *Service5) Sum(r *http.Request, req *Service5Request, res *Service5Response) error {
+	res.Result = req.A + req.B
+	for _, n := range req.Others {
+		res.Result += n
+	}
+	return nil
+}
+
+func TestServicesOptionalParams(t *testing.T) {
+	s := rpc.NewServer()
+	s.RegisterCodec(NewCodec(), "text/xml")
+	s.RegisterService(new(Service5), "")
+
+	tests := []struct {
+		Body   string
+		Result int
+	}{
+		{"<param><value><int>1</int></value></param>", 1},
+		{"<param><value><int>1</int></value></param><param><value><int>2</int></value></param>", 3},
+		{"<param><value><int>1</int></value></param><param><value><int>2</int></value></param>" +
+			"<param><value><int>3</int></value></param><param><value><int>4</int></value></param>", 10},
+	}
+	for i, test := range tests {
+		var res Service5Response
+		err := executeRaw(s, "<methodCall><methodName>Service5.Sum</methodName><params>"+test.Body+"</params></methodCall>", &res)
+		if err != nil {
+			t.Errorf("test %d: expected err to be nil, but got: %v", i, err)
+		} else if res.Result != test.Result {
+			t.Errorf("test %d: wrong response: %v.", i, res.Result)
+		}
+	}
+
+	var res Service5Response
+	err := executeRaw(s, "<methodCall><methodName>Service5.Sum</methodName><params></params></methodCall>", &res)
+	if err != FaultWrongArgumentsNumber {
+		t.Error("expected FaultWrongArgumentsNumber without the required param, but got:", err)
+	}
+	err = executeRaw(s, "<methodCall><methodName>Service5.Sum</methodName><params>"+
+		"<param><value><int>1</int></value></param><param><value><int>2</int></value></param>"+
+		"<param><value><string>x</string></value></param></params></methodCall>", &res)
+	if fault, ok := err.(Fault); !ok || !strings.Contains(fault.String, "Args.Others[0]") {
+		t.Error("expected fault for Args.Others[0], but got:", err)
+	}
+
+	// Trailing optional params left zero are omitted by clients.
+	xml, err := rpcRequest2XML("Service5.Sum", &Service5Request{A: 1})
+	if err != nil || strings.Contains(xml, "<int>0</int>") {
+		t.Error("expected optional param to be omitted, but got:", xml, err)
+	}
+	if err := execute(t, s, "Service5.Sum", &Service5Request{A: 1, Others: []int{5, 6}}, &res); err != nil || res.Result != 12 {
+		t.Error("expected variadic params to be sent, but got:", res.Result, err)
+	}
+}
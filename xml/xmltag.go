@@ -8,6 +8,13 @@ import (
 type XMLTag struct {
 	Name      string
 	OmitEmpty bool
+	// Any marks the catch-all map of unknown struct members, see
+	// CaptureUnknownMembers.
+	Any bool
+	// Optional and Variadic mark params that may be omitted, see
+	// paramLayout.
+	Optional bool
+	Variadic bool
 }
 
 func parseXMLTag(field reflect.StructField) *XMLTag {
@@ -15,13 +22,39 @@ func parseXMLTag(field reflect.StructField) *XMLTag {
 	if tag := field.Tag.Get("xml"); tag != "" {
 		tokens := strings.Split(tag, ",")
 		xml_tag.Name = tokens[0]
-		// Only "omitempty" is currently supported; ignore unsupported flags
+		// Only "omitempty", "any", "optional" and "variadic" are
+		// currently supported; ignore unsupported flags
 		for _, flag := range tokens[1:] {
-			if flag == "omitempty" {
+			switch flag {
+			case "omitempty":
 				xml_tag.OmitEmpty = true
-				break
+			case "any":
+				xml_tag.Any = true
+			case "optional":
+				xml_tag.Optional = true
+			case "variadic":
+				xml_tag.Variadic = true
 			}
 		}
 	}
 	return xml_tag
 }
+
+// paramLayout returns the number of params an args structure requires, and
+// the index of its variadic field, or -1.
+//
+// Fields tagged "optional" after the last required field may be omitted. The
+// last field, if it is a slice tagged "variadic", takes the remaining params.
+func paramLayout(t reflect.Type) (required, variadic int) {
+	variadic = -1
+	for i := 0; i < t.NumField(); i++ {
+		tag := parseXMLTag(t.Field(i))
+		switch {
+		case tag.Variadic && i == t.NumField()-1 && t.Field(i).Type.Kind() == reflect.Slice:
+			variadic = i
+		case !tag.Optional:
+			required = i + 1
+		}
+	}
+	return required, variadic
+}